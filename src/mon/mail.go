@@ -0,0 +1,86 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	mysmtp "github.com/fcavani/net/smtp"
+	"gopkg.in/ini.v1"
+)
+
+// mailer sends the alerts with the configuration of the mail section.
+type mailer struct {
+	sec      *ini.Section
+	auth     smtp.Auth
+	timeout  time.Duration
+	hostname string
+}
+
+func newMailer(cfg *ini.File) (*mailer, error) {
+	cfgMail := cfg.Section("mail")
+
+	smtpTimeout, err := cfgMail.Key("timeout").Int()
+	if err != nil {
+		return nil, e.New("invalid smtp timeout")
+	}
+
+	server := cfgMail.Key("smtp").String()
+	s := strings.Split(server, ":")
+	if len(s) > 0 {
+		server = s[0]
+	}
+
+	auth := smtp.PlainAuth(
+		"",
+		cfgMail.Key("account").String(),
+		cfgMail.Key("password").String(),
+		server,
+	)
+
+	hostname := "your system"
+	if hn, err := os.Hostname(); err == nil {
+		hostname = hn
+	}
+
+	return &mailer{
+		sec:      cfgMail,
+		auth:     auth,
+		timeout:  time.Duration(smtpTimeout) * time.Second,
+		hostname: hostname,
+	}, nil
+}
+
+// send sends the message with the subject to the configured address.
+func (ml *mailer) send(subject, msg string) error {
+	body := "Mime-Version: 1.0\n"
+	body += "Content-Type: text/plain; charset=utf-8\n"
+	body += "From:" + ml.sec.Key("from").String() + "\n"
+	body += "To:" + ml.sec.Key("to").String() + "\n"
+	body += "Subject: [" + ml.hostname + "] " + subject + "\n"
+	body += "Hi! This is " + ml.hostname + ".\n\n"
+	body += msg
+	body += "Tank you, our lazy boy.\n"
+	body += time.Now().Format(time.RFC1123Z)
+
+	err := mysmtp.SendMail(
+		ml.sec.Key("smtp").String(),
+		ml.auth,
+		ml.sec.Key("from").String(),
+		[]string{ml.sec.Key("to").String()},
+		ml.sec.Key("helo").String(),
+		[]byte(body),
+		ml.timeout,
+		false,
+	)
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
@@ -6,7 +6,7 @@ package main
 
 import (
 	"net"
-	"net/smtp"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -17,7 +17,6 @@ import (
 	"github.com/fcavani/e"
 	"github.com/fcavani/log"
 	"github.com/fcavani/net/dns"
-	mysmtp "github.com/fcavani/net/smtp"
 	flags "github.com/jessevdk/go-flags"
 	"gopkg.in/ini.v1"
 
@@ -32,11 +31,55 @@ type options struct {
 	Level string `short:"v" long:"level" description:"Log level for log going to stderr. The options are: nolog, panic, fatal, error, warning, info, debug and protocol." default:"debug"`
 }
 
+// monitorKeys are the service keys used by the monitor itself.
+var monitorKeys = []string{"url", "timeout", "periode", "sleep", "fails"}
+
+// serviceOptions returns the keys of the service section, and the ones
+// inherited from the service section, that are meant to the checker.
+func serviceOptions(cfg *ini.File, sec *ini.Section) monlite.Options {
+	opts := make(monlite.Options)
+	for _, s := range []*ini.Section{cfg.Section("service"), sec} {
+		for k, v := range s.KeysHash() {
+			opts[k] = v
+		}
+	}
+	for _, k := range monitorKeys {
+		delete(opts, k)
+	}
+	return opts
+}
+
+// report tells what the last check of the monitor found.
+func report(m *monlite.Monitor) string {
+	s := ""
+	if err := m.Err(); err != nil {
+		s += "Error:\n" + e.Trace(e.Forward(err)) + "\n"
+	}
+	if res := m.Result(); res != nil {
+		s += res.String() + "\n\n"
+	}
+	if d := m.Diagnosis(); d != "" {
+		s += "Diagnostics:\n" + d + "\n"
+	}
+	return s
+}
+
 func main() {
-	println("Starting monlite...")
 	// Configuration
 	var opts options
-	_, err := flags.Parse(&opts)
+	var runOpts runOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err := parser.AddCommand(
+		"run",
+		"Run a job and report it to monlite.",
+		"Run the command after -- and report its exit status, duration and the tail of its output to the heartbeat monitor of the service name. If monlite can't be reached and the job failed the alert is sent by e-mail.",
+		&runOpts,
+	)
+	if err != nil {
+		log.Fatal("can't add the run command:", err)
+	}
+	args, err := parser.Parse()
 	if err != nil {
 		log.Fatal("can't parse the command line options:", err)
 	}
@@ -45,6 +88,12 @@ func main() {
 		log.Fatal("Error reading configuratio file:", opts.Conf)
 	}
 
+	if parser.Active != nil && parser.Active.Name == "run" {
+		os.Exit(run(cfg, &runOpts, args))
+	}
+
+	println("Starting monlite...")
+
 	// Log stuff
 	println("Log...")
 	name := appname
@@ -110,29 +159,9 @@ func main() {
 
 	log.Println("Configuration...")
 
-	cfgMail := cfg.Section("mail")
-
-	smtpTimeout, err := cfgMail.Key("timeout").Int()
+	mail, err := newMailer(cfg)
 	if err != nil {
-		log.Fatal("invalid smtp timeout")
-	}
-
-	server := cfgMail.Key("smtp").String()
-	s := strings.Split(server, ":")
-	if len(s) > 0 {
-		server = s[0]
-	}
-
-	auth := smtp.PlainAuth(
-		"",
-		cfgMail.Key("account").String(),
-		cfgMail.Key("password").String(),
-		server,
-	)
-
-	hostname := "your system"
-	if hn, err := os.Hostname(); err == nil {
-		hostname = hn
+		log.Fatal(err)
 	}
 
 	mons := make([]*monlite.Monitor, 0)
@@ -165,63 +194,46 @@ func main() {
 			Periode: time.Duration(p) * time.Second,
 			Sleep:   time.Duration(sleep) * time.Second,
 			Fails:   fails,
+			Options: serviceOptions(cfg, sec),
 			OnFail: func(m *monlite.Monitor) error {
-
-				body := "Mime-Version: 1.0\n"
-				body += "Content-Type: text/plain; charset=utf-8\n"
-				body += "From:" + cfgMail.Key("from").String() + "\n"
-				body += "To:" + cfgMail.Key("to").String() + "\n"
-				body += "Subject: [" + hostname + "] " + "Monitor fail for " + m.Name + "\n"
-				body += "Hi! This is " + hostname + ".\n\n"
-				body += "Monitor fail for " + m.Name + " " + m.Url + "\n\n"
-				body += "Tank you, our lazy boy.\n"
-				body += time.Now().Format(time.RFC1123Z)
-
-				err := mysmtp.SendMail(
-					cfgMail.Key("smtp").String(),
-					auth,
-					cfgMail.Key("from").String(),
-					[]string{cfgMail.Key("to").String()},
-					cfgMail.Key("helo").String(),
-					[]byte(body),
-					time.Duration(smtpTimeout)*time.Second,
-					false,
-				)
-				if err != nil {
-					return e.Forward(err)
-				}
-				return nil
+				msg := "Monitor fail for " + m.Name + " " + m.Url + "\n\n"
+				msg += report(m)
+				return e.Forward(mail.send("Monitor fail for "+m.Name, msg))
 			},
 			OnUnFail: func(m *monlite.Monitor) error {
-
-				body := "Mime-Version: 1.0\n"
-				body += "Content-Type: text/plain; charset=utf-8\n"
-				body += "From:" + cfgMail.Key("from").String() + "\n"
-				body += "To:" + cfgMail.Key("to").String() + "\n"
-				body += "Subject: [" + hostname + "] " + "Monitor ok for " + m.Name + "\n"
-				body += "Hi! This is " + hostname + ".\n\n"
-				body += "Monitor ok for " + m.Name + " " + m.Url + "\n\n"
-				body += "Tank you, our lazy boy.\n"
-				body += time.Now().Format(time.RFC1123Z)
-
-				err := mysmtp.SendMail(
-					cfgMail.Key("smtp").String(),
-					auth,
-					cfgMail.Key("from").String(),
-					[]string{cfgMail.Key("to").String()},
-					cfgMail.Key("helo").String(),
-					[]byte(body),
-					time.Duration(smtpTimeout)*time.Second,
-					false,
-				)
-				if err != nil {
-					return e.Forward(err)
-				}
-				return nil
+				msg := "Monitor ok for " + m.Name + " " + m.Url + "\n\n"
+				return e.Forward(mail.send("Monitor ok for "+m.Name, msg))
 			},
 		})
 	}
 
+	listen := cfg.Section("heartbeat").Key("listen").String()
+	for _, m := range mons {
+		if !strings.HasPrefix(m.Url, "heartbeat://") {
+			continue
+		}
+		if listen == "" {
+			log.Fatalf("%v is a heartbeat monitor but there isn't an address to listen in the heartbeat section", m.Name)
+		}
+		err := monlite.Heartbeats.Watch(m)
+		if err != nil {
+			log.Fatalf("Failed to watch the heartbeat of %v. Error: %v", m.Name, err)
+		}
+	}
+	if listen != "" {
+		log.Printf("Listening heartbeats on %v", listen)
+		ln, err := listenHeartbeats(listen)
+		if err != nil {
+			log.Fatalf("Can't listen heartbeats on %v. Error: %v", listen, err)
+		}
+		go func() {
+			err := http.Serve(ln, monlite.Heartbeats)
+			if err != nil {
+				log.Fatalf("Heartbeat server failed: %v", err)
+			}
+		}()
+	}
+
 	log.Println("Starting monitors...")
 
 	for _, m := range mons {
@@ -233,7 +245,7 @@ func main() {
 
 	log.Println("Monitors ok!")
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM)
 	<-sig
 
@@ -0,0 +1,208 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+	"gopkg.in/ini.v1"
+)
+
+type runOptions struct {
+	Name    string        `short:"n" long:"name" description:"Name of the heartbeat service of the job." required:"true"`
+	Tail    int           `short:"t" long:"tail" description:"Bytes of the end of the output sent with the report." default:"4096"`
+	Timeout time.Duration `long:"timeout" description:"Timeout to talk with monlite." default:"10s"`
+	Quiet   bool          `short:"q" long:"quiet" description:"Don't copy the job output to stdout and stderr."`
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mutex sync.Mutex
+	max   int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return string(t.buf)
+}
+
+// listenHeartbeats listens in a tcp address or, if listen starts with
+// unix:, in an unix socket.
+func listenHeartbeats(listen string) (net.Listener, error) {
+	if strings.HasPrefix(listen, "unix:") {
+		path := strings.TrimPrefix(listen, "unix:")
+		os.Remove(path)
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, e.New(err)
+		}
+		return ln, nil
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	return ln, nil
+}
+
+// heartbeatClient returns a client and the base url to talk with the
+// heartbeat server listening in listen.
+func heartbeatClient(listen string, timeout time.Duration) (*http.Client, string, error) {
+	if strings.HasPrefix(listen, "unix:") {
+		path := strings.TrimPrefix(listen, "unix:")
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		return &http.Client{Transport: transport, Timeout: timeout}, "http://monlite", nil
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, "", e.New(err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return &http.Client{Timeout: timeout}, "http://" + net.JoinHostPort(host, port), nil
+}
+
+// heartbeatToken returns the token of the heartbeat monitor of the
+// service name.
+func heartbeatToken(cfg *ini.File, name string) (string, error) {
+	sec, err := cfg.GetSection("service." + name)
+	if err != nil {
+		return "", e.New("service %v not found", name)
+	}
+	u, err := url.Parse(sec.Key("url").String())
+	if err != nil {
+		return "", e.New(err)
+	}
+	if u.Scheme != "heartbeat" || u.Host == "" {
+		return "", e.New("service %v isn't a heartbeat monitor", name)
+	}
+	return u.Host, nil
+}
+
+func ping(client *http.Client, rawurl, body string) error {
+	resp, err := client.Post(rawurl, "text/plain; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		return e.New(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return e.New("monlite returned status code %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// run runs the job and reports it. It returns the exit code of the job.
+func run(cfg *ini.File, opts *runOptions, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "monlite: nothing to run, put the command after --")
+		return 2
+	}
+
+	var client *http.Client
+	var base string
+	token, err := heartbeatToken(cfg, opts.Name)
+	if err == nil {
+		client, base, err = heartbeatClient(cfg.Section("heartbeat").Key("listen").String(), opts.Timeout)
+	}
+	var report func(event, body string) error
+	if err != nil {
+		report = func(string, string) error { return err }
+	} else {
+		report = func(event, body string) error {
+			return ping(client, base+"/hb/"+token+event, body)
+		}
+	}
+
+	if err := report("/start", ""); err != nil {
+		fmt.Fprintln(os.Stderr, "monlite: can't report the job start:", err)
+	}
+
+	tail := &tailBuffer{max: opts.Tail}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	if opts.Quiet {
+		cmd.Stdout = tail
+		cmd.Stderr = tail
+	} else {
+		cmd.Stdout = io.MultiWriter(os.Stdout, tail)
+		cmd.Stderr = io.MultiWriter(os.Stderr, tail)
+	}
+	start := time.Now()
+	err = cmd.Run()
+	duration := time.Since(start)
+
+	code := 0
+	status := "exit status 0"
+	if exit, ok := err.(*exec.ExitError); ok {
+		code = exit.ExitCode()
+		if code < 0 {
+			code = 1
+		}
+		status = exit.Error()
+	} else if err != nil {
+		code = 127
+		status = err.Error()
+	}
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "command: %v\n", strings.Join(args, " "))
+	fmt.Fprintf(buf, "%v\n", status)
+	fmt.Fprintf(buf, "duration: %v\n\n", duration)
+	fmt.Fprintf(buf, "output:\n%v\n", tail)
+
+	event := ""
+	if code != 0 {
+		event = "/fail"
+	}
+	err = report(event, buf.String())
+	if err == nil {
+		return code
+	}
+	fmt.Fprintln(os.Stderr, "monlite: can't report the job:", err)
+	if code == 0 {
+		return code
+	}
+	mail, err := newMailer(cfg)
+	if err == nil {
+		msg := "Job fail for " + opts.Name + "\n\n" + buf.String() + "\n"
+		err = mail.send("Job fail for "+opts.Name, msg)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "monlite: can't send the alert:", err)
+	}
+	return code
+}
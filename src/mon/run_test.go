@@ -0,0 +1,90 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tail := &tailBuffer{max: 8}
+	fmt.Fprint(tail, "abc")
+	if got := tail.String(); got != "abc" {
+		t.Fatalf("got %q, expected abc", got)
+	}
+	n, err := fmt.Fprint(tail, "defghij")
+	if err != nil || n != 7 {
+		t.Fatalf("write returned %v %v, expected all the bytes", n, err)
+	}
+	if got := tail.String(); got != "cdefghij" {
+		t.Fatalf("got %q, expected the last 8 bytes", got)
+	}
+	fmt.Fprint(tail, strings.Repeat("x", 20)+"end")
+	if got := tail.String(); got != "xxxxxend" {
+		t.Fatalf("got %q, expected the end of the long write", got)
+	}
+}
+
+// serveOK answers OK to the requests and returns the paths requested.
+func serveOK(t *testing.T, ln net.Listener) chan string {
+	paths := make(chan string, 10)
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		fmt.Fprintln(w, "OK")
+	})}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return paths
+}
+
+func TestListenHeartbeats(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "monlite.sock")
+	// A socket left by a crash.
+	if err := os.WriteFile(sock, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		listen string
+	}{
+		{"unix:" + sock},
+		{"127.0.0.1:0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			ln, err := listenHeartbeats(tt.listen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := serveOK(t, ln)
+			listen := tt.listen
+			if !strings.HasPrefix(listen, "unix:") {
+				// The client talks to the loopback when the server
+				// listens in all the addresses.
+				_, port, _ := net.SplitHostPort(ln.Addr().String())
+				listen = "0.0.0.0:" + port
+			}
+			client, base, err := heartbeatClient(listen, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ping(client, base+"/hb/token/start", ""); err != nil {
+				t.Fatal(err)
+			}
+			if path := <-paths; path != "/hb/token/start" {
+				t.Fatalf("server got %v", path)
+			}
+		})
+	}
+	if _, err := listenHeartbeats("127.0.0.1:http-alt-invalid"); err == nil {
+		t.Fatal("expected an error for an invalid address")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/net/dns"
+	utilUrl "github.com/fcavani/net/url"
+)
+
+// lookupHost resolves the ipv4 and ipv6 addresses of host, giving up at
+// the deadline.
+func lookupHost(host string, deadline time.Time) ([]string, error) {
+	type lookup struct {
+		addrs []string
+		err   error
+	}
+	ch := make(chan lookup, 1)
+	go func() {
+		addrs, err := dns.LookupHost(host)
+		ch <- lookup{addrs, err}
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case l := <-ch:
+		return l.addrs, l.err
+	case <-timer.C:
+		return nil, e.New("timeout")
+	}
+}
+
+// resolve returns the addresses of the host of the url, in the order
+// of the resolver.
+func resolve(m *Monitor, url *url.URL) ([]net.IP, error) {
+	host := url.Hostname()
+	if _, addr, err := utilUrl.Socket(url.Host); err == nil {
+		host = addr
+		if h, _, err := net.SplitHostPort(addr); err == nil {
+			host = h
+		}
+	}
+	if host == "" {
+		return nil, e.New("url without host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, err := lookupHost(host, time.Now().Add(budget(m)))
+	if err != nil {
+		return nil, e.Push(err, "can't resolve "+host)
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, e.New("%v has no address", host)
+	}
+	return ips, nil
+}
+
+type addressResult struct {
+	ip  net.IP
+	res *Result
+	err error
+}
+
+// checkAddresses runs the checker f for each address of the url host,
+// at the same time, and decides the state with the policy:
+//
+//	first     only the first address is checked.
+//	all       all addresses must pass.
+//	any       at least one address must pass.
+//	ipv4-only all ipv4 addresses must pass, ipv6 ones aren't checked.
+//	ipv6-only all ipv6 addresses must pass, ipv4 ones aren't checked.
+//
+// The detail of the result has the outcome of each address and the
+// performance data are prefixed with the address.
+func checkAddresses(m *Monitor, url *url.URL, f Checker, policy string) (*Result, error) {
+	switch policy {
+	case "first", "all", "any", "ipv4-only", "ipv6-only":
+	default:
+		return nil, e.New("invalid addresses policy %v", policy)
+	}
+	ips, err := resolve(m, url)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	switch policy {
+	case "first":
+		ips = ips[:1]
+	case "ipv4-only", "ipv6-only":
+		v4 := policy == "ipv4-only"
+		filtered := make([]net.IP, 0, len(ips))
+		for _, ip := range ips {
+			if (ip.To4() != nil) == v4 {
+				filtered = append(filtered, ip)
+			}
+		}
+		if len(filtered) == 0 {
+			return nil, e.New("%v has no %v address", url.Hostname(), strings.TrimSuffix(policy, "-only"))
+		}
+		ips = filtered
+	}
+
+	ch := make(chan addressResult, len(ips))
+	for _, ip := range ips {
+		go func(ip net.IP) {
+			mc := *m
+			// The counters of each address are apart.
+			mc.Name = m.Name + "@" + ip.String()
+			mc.address = ip.String()
+			res, err := f(&mc, url)
+			ch <- addressResult{ip, res, err}
+		}(ip)
+	}
+	results := make(map[string]addressResult, len(ips))
+	for range ips {
+		r := <-ch
+		results[r.ip.String()] = r
+	}
+
+	res := &Result{changed: true}
+	lines := make([]string, 0, len(ips))
+	var failed []string
+	var first error
+	accepts := make([]func() error, 0)
+	for _, ip := range ips {
+		r := results[ip.String()]
+		if r.err != nil && (r.res == nil || !r.res.changed) {
+			res.changed = false
+		}
+		if r.res != nil {
+			if r.res.accept != nil {
+				accepts = append(accepts, r.res.accept)
+			}
+			for _, p := range r.res.Perf {
+				p.Label = ip.String() + " " + p.Label
+				res.Perf = append(res.Perf, p)
+			}
+			if r.res.State > res.State {
+				res.State = r.res.State
+			}
+		}
+		switch {
+		case r.err != nil:
+			failed = append(failed, ip.String())
+			if first == nil {
+				first = r.err
+			}
+			lines = append(lines, fmt.Sprintf("%v: %v", ip, r.err))
+		case r.res != nil:
+			lines = append(lines, fmt.Sprintf("%v: %v: %v", ip, r.res.State, r.res.Summary))
+		default:
+			lines = append(lines, fmt.Sprintf("%v: OK", ip))
+		}
+	}
+	res.Summary = fmt.Sprintf("%v of %v addresses of %v passed", len(ips)-len(failed), len(ips), url.Hostname())
+	res.Detail = strings.Join(lines, "\n")
+	if len(accepts) > 0 {
+		res.accept = func() error {
+			for _, accept := range accepts {
+				if err := accept(); err != nil {
+					return e.Forward(err)
+				}
+			}
+			return nil
+		}
+	}
+	if len(failed) == 0 || (policy == "any" && len(failed) < len(ips)) {
+		res.changed = false
+		if policy == "any" {
+			res.State = StateOk
+		}
+		return res, nil
+	}
+	if res.State == StateOk {
+		res.State = StateCritical
+	}
+	return res, e.Push(first, fmt.Sprintf("%v failed", strings.Join(failed, ", ")))
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestCheckAddresses(t *testing.T) {
+	// localhost resolves to 127.0.0.1 and ::1 without a dns server.
+	u, err := url.Parse("tcp://localhost:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	literal, err := url.Parse("tcp://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		url     *url.URL
+		policy  string
+		fail    string
+		checked []string
+		summary string
+		state   State
+		err     string
+	}{
+		{"first", u, "first", "", []string{"127.0.0.1"}, "1 of 1 addresses of localhost passed", StateOk, ""},
+		{"all", u, "all", "", []string{"127.0.0.1", "::1"}, "2 of 2 addresses of localhost passed", StateOk, ""},
+		{"all with a failure", u, "all", "::1", []string{"127.0.0.1", "::1"}, "1 of 2 addresses of localhost passed", StateCritical, "::1 failed"},
+		{"any with a failure", u, "any", "::1", []string{"127.0.0.1", "::1"}, "1 of 2 addresses of localhost passed", StateOk, ""},
+		{"any all failed", u, "any", "127.0.0.1,::1", []string{"127.0.0.1", "::1"}, "0 of 2 addresses of localhost passed", StateCritical, "127.0.0.1, ::1 failed"},
+		{"ipv4 only", u, "ipv4-only", "::1", []string{"127.0.0.1"}, "1 of 1 addresses of localhost passed", StateOk, ""},
+		{"ipv6 only", u, "ipv6-only", "127.0.0.1", []string{"::1"}, "1 of 1 addresses of localhost passed", StateOk, ""},
+		{"ipv6 only failed", u, "ipv6-only", "::1", []string{"::1"}, "0 of 1 addresses of localhost passed", StateCritical, "::1 failed"},
+		{"ip", literal, "all", "", []string{"127.0.0.1"}, "1 of 1 addresses of 127.0.0.1 passed", StateOk, ""},
+		{"ip without ipv6", literal, "ipv6-only", "", nil, "", StateOk, "127.0.0.1 has no ipv6 address"},
+		{"invalid", u, "some", "", nil, "", StateOk, "invalid addresses policy some"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			checked := make([]string, 0)
+			f := func(m *Monitor, u *url.URL) (*Result, error) {
+				mutex.Lock()
+				checked = append(checked, m.address)
+				mutex.Unlock()
+				if !strings.HasSuffix(m.Name, "@"+m.address) {
+					t.Errorf("name %v without the address %v", m.Name, m.address)
+				}
+				res := &Result{Summary: "connected", Perf: []Perf{{Label: "time", Value: 1}}}
+				for _, ip := range strings.Split(tt.fail, ",") {
+					if ip == m.address {
+						return res, e.New("connection refused")
+					}
+				}
+				return res, nil
+			}
+			m := &Monitor{Name: "addresses " + tt.name, Timeout: 5 * time.Second}
+			res, err := checkAddresses(m, tt.url, f, tt.policy)
+			sort.Strings(checked)
+			if strings.Join(checked, ",") != strings.Join(tt.checked, ",") {
+				t.Fatalf("checked %v, expected %v", checked, tt.checked)
+			}
+			if tt.summary == "" {
+				if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+					t.Fatalf("error %v, expected %q", err, tt.err)
+				}
+				return
+			}
+			if tt.err == "" && err != nil {
+				t.Fatal(e.Trace(err))
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(e.Trace(err), tt.err)) {
+				t.Fatalf("error %v, expected %q", err, tt.err)
+			}
+			if res.Summary != tt.summary || res.State != tt.state {
+				t.Fatalf("result %v %v, expected %v %v", res.State, res.Summary, tt.state, tt.summary)
+			}
+			if len(res.Perf) != len(tt.checked) || !strings.HasPrefix(res.Perf[0].Label, tt.checked[0]+" ") {
+				t.Fatalf("perf %v without the addresses", res.Perf)
+			}
+		})
+	}
+}
+
+func TestCheckAddressesProxy(t *testing.T) {
+	m := &Monitor{
+		Name:    "addresses proxy",
+		Url:     "tcp://localhost:1",
+		Timeout: 5 * time.Second,
+		Options: Options{"proxy": "socks5://127.0.0.1:1080", "addresses": "any"},
+	}
+	_, err := check(m)
+	if err == nil || !strings.Contains(e.Trace(err), "addresses can't be used with proxy") {
+		t.Fatalf("error %v, expected the addresses and proxy keys refused", err)
+	}
+}
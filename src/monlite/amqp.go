@@ -0,0 +1,126 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fcavani/e"
+	utilUrl "github.com/fcavani/net/url"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// CheckAMQP connects to the AMQP 0-9-1 broker in
+// amqp[s]://user:password@host[:port]/vhost through the dialer of the
+// monitor, does the handshake and opens a channel. amqps uses the TLS
+// keys of tlsConfig. If the key queue is set the queue is declared
+// passively, it must exist, and:
+//
+//	messages  the queue must have less ready messages than this, 0
+//	          for no limit.
+//	consumers minimum number of consumers (0).
+func CheckAMQP(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	maxMessages, err := opts.Int("messages", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	minConsumers, err := opts.Int("consumers", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	var config amqp.Config
+	if url.Scheme == "amqps" {
+		config.TLSClientConfig, err = tlsConfig(opts, url.Hostname())
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+	}
+	deadline := time.Now().Add(budget(m))
+	var raw net.Conn
+	config.Dial = func(network, addr string) (net.Conn, error) {
+		conn, err := d.Dial(network, addr)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		conn.SetDeadline(deadline)
+		raw = conn
+		return conn, nil
+	}
+	// The keys aren't for the library.
+	u := utilUrl.Copy(url)
+	u.RawQuery = ""
+
+	start := time.Now()
+	conn, err := amqp.DialConfig(u.String(), config)
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't connect")
+	}
+	defer conn.Close()
+	// The library clears the deadline after the handshake.
+	raw.SetDeadline(deadline)
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't open a channel")
+	}
+	defer ch.Close()
+	props := conn.Properties
+	res := &Result{
+		Summary: fmt.Sprintf("%v %v", props["product"], props["version"]),
+		Perf:    []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}},
+	}
+
+	name := opts.String("queue", "")
+	if name == "" {
+		return res, nil
+	}
+	q, err := ch.QueueDeclarePassive(name, false, false, false, false, nil)
+	if err != nil {
+		res.State = StateCritical
+		return res, e.Push(e.New(err), "can't declare the queue "+name)
+	}
+	err = amqpQueue(res, q, maxMessages, minConsumers)
+	if err != nil {
+		return res, e.Forward(err)
+	}
+	return res, nil
+}
+
+// amqpQueue puts the messages and consumers of the queue q in res and
+// checks them against the limits.
+func amqpQueue(res *Result, q amqp.Queue, maxMessages, minConsumers int) error {
+	name := q.Name
+	res.Summary = fmt.Sprintf("queue %v has %v messages and %v consumers", name, q.Messages, q.Consumers)
+	crit := ""
+	if maxMessages > 0 {
+		crit = strconv.Itoa(maxMessages)
+	}
+	res.Perf = append(res.Perf,
+		Perf{Label: "messages", Value: float64(q.Messages), Crit: crit, Min: "0"},
+		Perf{Label: "consumers", Value: float64(q.Consumers), Min: "0"},
+	)
+	if maxMessages > 0 && q.Messages >= maxMessages {
+		res.State = StateCritical
+		return e.New("queue %v has %v messages, the limit is %v", name, q.Messages, maxMessages)
+	}
+	if q.Consumers < minConsumers {
+		res.State = StateCritical
+		return e.New("queue %v has %v consumers, the minimum is %v", name, q.Consumers, minConsumers)
+	}
+	return nil
+}
+
+func init() {
+	Add("amqp", CheckAMQP)
+	Add("amqps", CheckAMQP)
+}
@@ -0,0 +1,55 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fcavani/e"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestAMQPQueue(t *testing.T) {
+	tests := []struct {
+		name         string
+		messages     int
+		consumers    int
+		maxMessages  int
+		minConsumers int
+		crit         string
+		state        State
+		err          string
+	}{
+		{"no limits", 1000, 0, 0, 0, "", StateOk, ""},
+		{"below the limit", 9, 1, 10, 1, "10", StateOk, ""},
+		{"at the limit", 10, 1, 10, 0, "10", StateCritical, "queue jobs has 10 messages, the limit is 10"},
+		{"above the limit", 11, 1, 10, 0, "10", StateCritical, "queue jobs has 11 messages, the limit is 10"},
+		{"minimum consumers", 0, 2, 0, 2, "", StateOk, ""},
+		{"few consumers", 0, 1, 0, 2, "", StateCritical, "queue jobs has 1 consumers, the minimum is 2"},
+		{"empty without consumers", 0, 0, 10, 1, "10", StateCritical, "0 consumers, the minimum is 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &Result{}
+			q := amqp.Queue{Name: "jobs", Messages: tt.messages, Consumers: tt.consumers}
+			err := amqpQueue(res, q, tt.maxMessages, tt.minConsumers)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+			} else if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q", err, tt.err)
+			}
+			if res.State != tt.state {
+				t.Fatalf("state %v, expected %v", res.State, tt.state)
+			}
+			if len(res.Perf) != 2 || res.Perf[0].Label != "messages" || res.Perf[0].Value != float64(tt.messages) ||
+				res.Perf[0].Crit != tt.crit || res.Perf[1].Value != float64(tt.consumers) {
+				t.Fatalf("perf %+v", res.Perf)
+			}
+		})
+	}
+}
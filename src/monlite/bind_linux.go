@@ -0,0 +1,54 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"syscall"
+
+	"github.com/fcavani/e"
+)
+
+// bindControl returns the function that binds the socket to the network
+// interface iface with SO_BINDTODEVICE, nil if iface is empty.
+func bindControl(iface string) func(network, address string, c syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if err != nil {
+			return e.New(err)
+		}
+		if serr != nil {
+			return e.Push(e.New(serr), "can't bind to the interface "+iface)
+		}
+		return nil
+	}
+}
+
+// ttlControl returns the function that sets the TTL, or the hop limit
+// for ipv6, of the socket.
+func ttlControl(ttl int, v6 bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if v6 {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+			} else {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+			}
+		})
+		if err != nil {
+			return e.New(err)
+		}
+		if serr != nil {
+			return e.Push(e.New(serr), "can't set the ttl")
+		}
+		return nil
+	}
+}
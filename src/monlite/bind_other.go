@@ -0,0 +1,32 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package monlite
+
+import (
+	"syscall"
+
+	"github.com/fcavani/e"
+)
+
+// bindControl returns nil if iface is empty, otherwise a function that
+// fails, binding to an interface is only supported in Linux.
+func bindControl(iface string) func(network, address string, c syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return e.New("binding to the interface %v isn't supported in this system", iface)
+	}
+}
+
+// ttlControl returns a function that fails, setting the TTL is only
+// supported in Linux.
+func ttlControl(ttl int, v6 bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return e.New("setting the ttl isn't supported in this system")
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fcavani/e"
+)
+
+// MaxDiffLines is the maximum number of lines of the diff in the alert.
+var MaxDiffLines = 40
+
+var spaces = regexp.MustCompile(`[ \t\r]+`)
+
+// normalize removes from body the matches of the regexps in the key
+// ignore and in the keys ignore.<name>, collapses the spaces and
+// removes the empty lines.
+func normalize(opts Options, body []byte) (string, error) {
+	exprs := make([]string, 0)
+	if ignore := opts.String("ignore", ""); ignore != "" {
+		exprs = append(exprs, ignore)
+	}
+	names := make([]string, 0)
+	for k := range opts.Prefixed("ignore") {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		exprs = append(exprs, opts.String("ignore."+k, ""))
+	}
+	text := string(body)
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", e.Push(e.New(err), "invalid ignore "+expr)
+		}
+		text = re.ReplaceAllString(text, "")
+	}
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		l = strings.TrimSpace(spaces.ReplaceAllString(l, " "))
+		if l != "" {
+			out = append(out, l)
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func contentHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// unifiedDiff returns a unified diff of the region between the common
+// beginning and end of old and new, with three lines of context and at
+// most MaxDiffLines lines.
+func unifiedDiff(old, new string) string {
+	a, b := strings.Split(old, "\n"), strings.Split(new, "\n")
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	const context = 3
+	start := prefix - context
+	if start < 0 {
+		start = 0
+	}
+	endA, endB := len(a)-suffix, len(b)-suffix
+	tailA, tailB := endA+context, endB+context
+	if tailA > len(a) {
+		tailA = len(a)
+	}
+	if tailB > len(b) {
+		tailB = len(b)
+	}
+	lines := []string{
+		"--- previous",
+		"+++ current",
+		fmt.Sprintf("@@ -%v,%v +%v,%v @@", start+1, tailA-start, start+1, tailB-start),
+	}
+	for _, l := range a[start:prefix] {
+		lines = append(lines, " "+l)
+	}
+	for _, l := range a[prefix:endA] {
+		lines = append(lines, "-"+l)
+	}
+	for _, l := range b[prefix:endB] {
+		lines = append(lines, "+"+l)
+	}
+	for _, l := range b[endB:tailB] {
+		lines = append(lines, " "+l)
+	}
+	if len(lines) > MaxDiffLines {
+		lines = append(lines[:MaxDiffLines], fmt.Sprintf("... %v more lines", len(lines)-MaxDiffLines))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// baselineRead returns the content stored in file, found is false if
+// the file doesn't exist.
+func baselineRead(file string) (prev string, found bool, err error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, e.Push(e.New(err), "can't read the baseline")
+	}
+	return string(b), true, nil
+}
+
+// baselineWrite stores text in file.
+func baselineWrite(file, text string) error {
+	tmp := file + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(text), 0600)
+	if err != nil {
+		return e.Push(e.New(err), "can't write the baseline")
+	}
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return e.Push(e.New(err), "can't write the baseline")
+	}
+	return nil
+}
+
+// contentChange compares the normalized body with the baseline and
+// returns the diff, empty if it didn't change. accept, if not nil,
+// makes the new content the baseline of the next check: the monitor
+// calls it when the check passes or after the alert of the change is
+// delivered, so a change is reported until someone is told. The first
+// check only stores the baseline. The baseline is kept in memory, and
+// lost in a restart, unless the key baseline is the file to keep it.
+func contentChange(m *Monitor, opts Options, body []byte) (hash, diff string, accept func() error, err error) {
+	text, err := normalize(opts, body)
+	if err != nil {
+		return "", "", nil, e.Forward(err)
+	}
+	hash = contentHash(text)
+	var prev string
+	var found bool
+	file := opts.String("baseline", "")
+	if file != "" {
+		prev, found, err = baselineRead(file)
+		if err != nil {
+			return "", "", nil, e.Forward(err)
+		}
+	} else {
+		prev, found = recall(m, "content")
+	}
+	if found && contentHash(prev) == hash {
+		return hash, "", nil, nil
+	}
+	accept = func() error {
+		if file != "" {
+			return baselineWrite(file, text)
+		}
+		remember(m, "content", text)
+		return nil
+	}
+	if !found {
+		return hash, "", accept, nil
+	}
+	return hash, unifiedDiff(prev, text), accept, nil
+}
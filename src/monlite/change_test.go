@@ -0,0 +1,156 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(from, to int) string {
+		l := make([]string, 0)
+		for i := from; i <= to; i++ {
+			l = append(l, fmt.Sprint("line ", i))
+		}
+		return strings.Join(l, "\n")
+	}
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"changed line", "a\nb\nc", "a\nB\nc",
+			"--- previous\n+++ current\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c"},
+		{"added at the end", "a\nb", "a\nb\nc",
+			"--- previous\n+++ current\n@@ -1,2 +1,3 @@\n a\n b\n+c"},
+		{"removed at the beginning", "a\nb", "b",
+			"--- previous\n+++ current\n@@ -1,2 +1,1 @@\n-a\n b"},
+		{"context of three lines", lines(1, 9), strings.Replace(lines(1, 9), "line 5", "five", 1),
+			"--- previous\n+++ current\n@@ -2,7 +2,7 @@\n line 2\n line 3\n line 4\n-line 5\n+five\n line 6\n line 7\n line 8"},
+		{"repeated lines", "x\nx\nx", "x\nx",
+			"--- previous\n+++ current\n@@ -1,3 +1,2 @@\n x\n x\n-x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff(tt.old, tt.new)
+			if got != tt.want {
+				t.Fatalf("got\n%v\nexpected\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffLimit(t *testing.T) {
+	old := make([]string, 100)
+	for i := range old {
+		old[i] = fmt.Sprint(i)
+	}
+	got := strings.Split(unifiedDiff(strings.Join(old, "\n"), "other"), "\n")
+	if len(got) != MaxDiffLines+1 || got[MaxDiffLines] != "... 64 more lines" {
+		t.Fatalf("%v lines, the last %q", len(got), got[len(got)-1])
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	opts := Options{"ignore": `\d{2}:\d{2}`, "ignore.nonce": `nonce="[^"]*"`}
+	got, err := normalize(opts, []byte("  <p>at 12:30</p>\r\n\n\t<script nonce=\"abc\">x  y</script>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<p>at </p>\n<script >x y</script>"; got != want {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+	_, err = normalize(Options{"ignore": "("}, nil)
+	if err == nil {
+		t.Fatal("expected an error for an invalid regexp")
+	}
+}
+
+func TestContentChangeBaseline(t *testing.T) {
+	opts := Options{"baseline": filepath.Join(t.TempDir(), "baseline")}
+	change := func(m *Monitor, body string, accept bool) string {
+		_, diff, acceptFn, err := contentChange(m, opts, []byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept && acceptFn != nil {
+			if err := acceptFn(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return diff
+	}
+	m := &Monitor{Name: "baseline"}
+	if diff := change(m, "a\nb", true); diff != "" {
+		t.Fatalf("first check: %q", diff)
+	}
+	if diff := change(m, "a\nb", true); diff != "" {
+		t.Fatalf("same content: %q", diff)
+	}
+	// A restart, the baseline is read from the file.
+	m = &Monitor{Name: "baseline restarted"}
+	if diff := change(m, "a\nc", false); !strings.Contains(diff, "-b\n+c") {
+		t.Fatalf("change after a restart: %q", diff)
+	}
+	// Not accepted, the change is reported again.
+	if diff := change(m, "a\nc", true); !strings.Contains(diff, "-b\n+c") {
+		t.Fatalf("change not reported again: %q", diff)
+	}
+	if diff := change(m, "a\nc", true); diff != "" {
+		t.Fatalf("change reported after accepted: %q", diff)
+	}
+}
+
+func TestChangeAlert(t *testing.T) {
+	body := "a"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer s.Close()
+	alerts := make([]string, 0)
+	var deliver error
+	m := &Monitor{
+		Name:    "change alert",
+		Url:     s.URL,
+		Timeout: 5 * time.Second,
+		Options: Options{"change": "true"},
+		OnFail: func(m *Monitor) error {
+			if m.Diagnosis() != "" {
+				t.Errorf("diagnosis of a content change: %v", m.Diagnosis())
+			}
+			alerts = append(alerts, m.Result().Detail)
+			return deliver
+		},
+	}
+	t.Cleanup(func() { forgetSamples(m) })
+	run := func() {
+		res, err := check(m)
+		m.consume(pong{res, err})
+	}
+	run()
+	body = "b"
+	deliver = e.New("smtp server down")
+	run()
+	deliver = nil
+	run()
+	run()
+	if len(alerts) != 2 {
+		t.Fatalf("%v alerts, expected the undelivered one again: %v", len(alerts), alerts)
+	}
+	for _, a := range alerts {
+		if !strings.Contains(a, "-a\n+b") {
+			t.Fatalf("alert %q, expected the diff of a to b", a)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestCheckDialerKeys(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	tests := []struct {
+		name    string
+		url     string
+		opts    Options
+		summary string
+		err     string
+	}{
+		{"tcp source", "tcp://" + ln.Addr().String(), Options{"source": "127.0.0.1"}, "(source 127.0.0.1)", ""},
+		{"exec", "exec://true", nil, "", ""},
+		{"exec source", "exec://true", Options{"source": "127.0.0.1"}, "", "scheme exec doesn't support the keys source"},
+		{"file proxy", "file:///etc/hosts", Options{"proxy": "socks5://127.0.0.1:1080"}, "", "doesn't support the keys proxy"},
+		{"ping proxy", "udp://127.0.0.1:53", Options{"proxy": "socks5://127.0.0.1:1080", "addresses": "all"}, "", "scheme udp doesn't support the keys proxy, addresses"},
+		{"dnsbl source", "dnsbl://192.0.2.99", Options{"source": "127.0.0.1"}, "", "scheme dnsbl doesn't support the keys source"},
+		{"couch write", "couch://127.0.0.1:5984", Options{"mode": "write", "interface": "lo"}, "", "mode write doesn't support the keys interface"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: tt.url, Timeout: 5 * time.Second, Options: tt.opts}
+			res, err := check(m)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+					t.Fatalf("error %v, expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(e.Trace(err))
+			}
+			if res == nil || !strings.Contains(res.Summary, tt.summary) {
+				t.Fatalf("result %v, expected %q in the summary", res, tt.summary)
+			}
+			if tt.summary == "" && strings.Contains(res.Summary, "source") {
+				t.Fatalf("summary %q has a source", res.Summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fcavani/e"
+	utilUrl "github.com/fcavani/net/url"
+	"github.com/fcavani/ping"
+)
+
+// CheckCouch checks a CouchDB server without writing to it. The key
+// mode is a comma separated list of the checks to run:
+//
+//	up          GET /_up must say ok (default).
+//	info        GET / must return the welcome message and the version.
+//	replication no replication job may be crashing. If the key
+//	            replications is set it is the minimum number of
+//	            running replications, the jobs only added to the
+//	            scheduler don't count.
+//	doc         GET /db/docid, where db/docid is in the key doc.
+//	view        GET /db/_design/ddoc/_view/name, where the path is in
+//	            the key view.
+//	write       creates a database, writes, reads and deletes it.
+//	            This needs admin credentials, use only if you mean it.
+//	            It doesn't support the keys proxy, source, interface
+//	            and addresses.
+func CheckCouch(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	url = utilUrl.Copy(url)
+	url.Scheme = "http"
+	url.RawQuery = ""
+	modes := opts.List("mode")
+	if len(modes) == 0 {
+		modes = []string{"up"}
+	}
+	c := &couchClient{
+		url:    url,
+		client: &http.Client{Transport: d.Transport(nil), Timeout: budget(m)},
+	}
+	for _, mode := range modes {
+		var err error
+		switch mode {
+		case "up":
+			err = c.up()
+		case "info":
+			err = c.info()
+		case "replication":
+			err = c.replication(opts.String("replications", "0"))
+		case "doc":
+			err = c.read(opts.String("doc", ""))
+		case "view":
+			err = c.read(opts.String("view", ""))
+		case "write":
+			// PingCouch doesn't use the dialer.
+			if keys := dialerKeys(opts); len(keys) > 0 {
+				err = e.New("mode write doesn't support the keys %v", strings.Join(keys, ", "))
+				break
+			}
+			err = ping.PingCouch(url)
+		default:
+			err = e.New("unknown couch mode %v", mode)
+		}
+		if err != nil {
+			return nil, e.Push(err, "couch check "+mode+" failed")
+		}
+	}
+	return nil, nil
+}
+
+type couchClient struct {
+	url    *url.URL
+	client *http.Client
+}
+
+func (c *couchClient) get(path string, v interface{}) (int, error) {
+	u := utilUrl.Copy(c.url)
+	u.User = nil
+	u.Path = "/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, e.New(err)
+	}
+	if c.url.User != nil {
+		pass, _ := c.url.User.Password()
+		req.SetBasicAuth(c.url.User.Username(), pass)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, e.Push(e.New(err), "get failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, e.New("returned status code %v, expected 2xx", resp.StatusCode)
+	}
+	if v == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		if err != nil {
+			return resp.StatusCode, e.New(err)
+		}
+		return resp.StatusCode, nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return resp.StatusCode, e.Push(e.New(err), "invalid response")
+	}
+	return resp.StatusCode, nil
+}
+
+func (c *couchClient) up() error {
+	var up struct {
+		Status string `json:"status"`
+	}
+	_, err := c.get("/_up", &up)
+	if err != nil {
+		return e.Forward(err)
+	}
+	if up.Status != "ok" {
+		return e.New("server status is %v", up.Status)
+	}
+	return nil
+}
+
+func (c *couchClient) info() error {
+	var info struct {
+		Couchdb string `json:"couchdb"`
+		Version string `json:"version"`
+	}
+	_, err := c.get("/", &info)
+	if err != nil {
+		return e.Forward(err)
+	}
+	if info.Couchdb == "" || info.Version == "" {
+		return e.New("server didn't send its version")
+	}
+	return nil
+}
+
+func (c *couchClient) replication(min string) error {
+	n, err := strconv.Atoi(min)
+	if err != nil {
+		return e.Push(e.New(err), "invalid replications")
+	}
+	var jobs struct {
+		Jobs []struct {
+			Id      string `json:"id"`
+			DocId   string `json:"doc_id"`
+			History []struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"history"`
+		} `json:"jobs"`
+	}
+	code, err := c.get("/_scheduler/jobs", &jobs)
+	if code == http.StatusNotFound || code == http.StatusBadRequest {
+		// CouchDB 1.x has only the active tasks.
+		return e.Forward(c.activeTasks(n))
+	} else if err != nil {
+		return e.Forward(err)
+	}
+	running := 0
+	for _, job := range jobs.Jobs {
+		if len(job.History) == 0 {
+			continue
+		}
+		// The history is newest first.
+		switch job.History[0].Type {
+		case "crashed":
+			return e.New("replication %v (%v) crashed: %v", job.DocId, job.Id, job.History[0].Reason)
+		case "started":
+			running++
+		}
+	}
+	if running < n {
+		return e.New("only %v replications are running, expected %v", running, n)
+	}
+	return nil
+}
+
+func (c *couchClient) activeTasks(min int) error {
+	var tasks []struct {
+		Type string `json:"type"`
+	}
+	_, err := c.get("/_active_tasks", &tasks)
+	if err != nil {
+		return e.Forward(err)
+	}
+	running := 0
+	for _, task := range tasks {
+		if task.Type == "replication" {
+			running++
+		}
+	}
+	if running < min {
+		return e.New("only %v replications are running, expected %v", running, min)
+	}
+	return nil
+}
+
+func (c *couchClient) read(path string) error {
+	if path == "" {
+		return e.New("nothing to read, set the document or view path")
+	}
+	_, err := c.get(path, nil)
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+func init() {
+	Add("couch", CheckCouch)
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// fakeCouch answers with the bodies of the paths, 404 to the others.
+func fakeCouch(t *testing.T, paths map[string]string) string {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		body, ok := paths[r.URL.Path]
+		if !ok {
+			http.Error(w, `{"error":"not_found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestCheckCouch(t *testing.T) {
+	server := map[string]string{
+		"/_up":                             `{"status":"ok"}`,
+		"/":                                `{"couchdb":"Welcome","version":"3.3.2"}`,
+		"/users/alice":                     `{"_id":"alice"}`,
+		"/users/_design/auth/_view/byname": `{"total_rows":0,"rows":[]}`,
+		"/_scheduler/jobs": `{"total_rows":3,"jobs":[
+			{"id":"a+continuous","doc_id":"a","history":[{"type":"started"},{"type":"added"}]},
+			{"id":"b+continuous","doc_id":"b","history":[{"type":"started"},{"type":"added"}]},
+			{"id":"c+continuous","doc_id":"c","history":[{"type":"added"}]}]}`,
+	}
+	crashed := map[string]string{
+		"/_scheduler/jobs": `{"jobs":[{"id":"a+continuous","doc_id":"a","history":[{"type":"crashed","reason":"db_not_found"},{"type":"started"}]}]}`,
+	}
+	// CouchDB 1.x has no scheduler.
+	old := map[string]string{
+		"/_active_tasks": `[{"type":"replication"},{"type":"indexer"}]`,
+	}
+	down := map[string]string{
+		"/_up": `{"status":"maintenance_mode"}`,
+	}
+	tests := []struct {
+		name   string
+		server map[string]string
+		opts   Options
+		err    string
+	}{
+		{"up", server, nil, ""},
+		{"down", down, nil, "server status is maintenance_mode"},
+		{"info", server, Options{"mode": "info"}, ""},
+		{"info without version", down, Options{"mode": "info"}, "couch check info failed"},
+		{"replication", server, Options{"mode": "replication", "replications": "2"}, ""},
+		{"added jobs don't run", server, Options{"mode": "replication", "replications": "3"}, "only 2 replications are running, expected 3"},
+		{"crashed", crashed, Options{"mode": "replication"}, "replication a (a+continuous) crashed: db_not_found"},
+		{"active tasks", old, Options{"mode": "replication", "replications": "1"}, ""},
+		{"few active tasks", old, Options{"mode": "replication", "replications": "2"}, "only 1 replications are running, expected 2"},
+		{"doc", server, Options{"mode": "doc", "doc": "users/alice"}, ""},
+		{"missing doc", server, Options{"mode": "doc", "doc": "users/bob"}, "returned status code 404"},
+		{"doc without path", server, Options{"mode": "doc"}, "nothing to read"},
+		{"view", server, Options{"mode": "up,view", "view": "users/_design/auth/_view/byname"}, ""},
+		{"unknown mode", server, Options{"mode": "up,replicate"}, "unknown couch mode replicate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{
+				Name:    "couch " + tt.name,
+				Url:     "couch://admin:secret@" + fakeCouch(t, tt.server),
+				Timeout: 5 * time.Second,
+				Options: tt.opts,
+			}
+			_, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+			} else if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckCouchAuth(t *testing.T) {
+	m := &Monitor{Name: "couch auth", Url: "couch://admin:wrong@" + fakeCouch(t, nil), Timeout: 5 * time.Second}
+	_, err := check(m)
+	if err == nil || !strings.Contains(e.Trace(err), "returned status code 401") {
+		t.Fatalf("expected the authorization to fail, got %v", err)
+	}
+}
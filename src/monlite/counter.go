@@ -0,0 +1,85 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"sync"
+	"time"
+)
+
+type sample struct {
+	value float64
+	when  time.Time
+}
+
+var samples = make(map[string]sample)
+var samplesMutex sync.Mutex
+
+// delta stores the value of the counter name of the monitor and returns
+// its difference from the previous value and the time between them.
+// ok is false if there isn't a previous value or if the counter was
+// reset.
+func delta(m *Monitor, name string, value float64) (d float64, dt time.Duration, ok bool) {
+	samplesMutex.Lock()
+	defer samplesMutex.Unlock()
+	key := m.Name + "\x00" + name
+	now := time.Now()
+	prev, found := samples[key]
+	samples[key] = sample{value, now}
+	if !found || value < prev.value {
+		return 0, 0, false
+	}
+	return value - prev.value, now.Sub(prev.when), true
+}
+
+// rate is like delta but returns the change per second.
+func rate(m *Monitor, name string, value float64) (float64, bool) {
+	d, dt, ok := delta(m, name, value)
+	if !ok || dt <= 0 {
+		return 0, false
+	}
+	return d / dt.Seconds(), true
+}
+
+// counterRate is like rate for counters that restart from zero, as the
+// Prometheus ones: after a reset the increase is the new value.
+func counterRate(m *Monitor, name string, value float64) (float64, bool) {
+	samplesMutex.Lock()
+	defer samplesMutex.Unlock()
+	key := m.Name + "\x00" + name
+	now := time.Now()
+	prev, found := samples[key]
+	samples[key] = sample{value, now}
+	dt := now.Sub(prev.when)
+	if !found || dt <= 0 {
+		return 0, false
+	}
+	d := value - prev.value
+	if d < 0 {
+		d = value
+	}
+	return d / dt.Seconds(), true
+}
+
+var values = make(map[string]string)
+
+// remember stores the value of name for the monitor and returns the
+// value stored before, if any.
+func remember(m *Monitor, name, value string) (prev string, found bool) {
+	samplesMutex.Lock()
+	defer samplesMutex.Unlock()
+	key := m.Name + "\x00" + name
+	prev, found = values[key]
+	values[key] = value
+	return
+}
+
+// recall returns the value of name stored by remember.
+func recall(m *Monitor, name string) (value string, found bool) {
+	samplesMutex.Lock()
+	defer samplesMutex.Unlock()
+	value, found = values[m.Name+"\x00"+name]
+	return
+}
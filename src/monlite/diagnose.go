@@ -0,0 +1,429 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/net/dns"
+	utilUrl "github.com/fcavani/net/url"
+)
+
+// TraceTimeout is how long the traceroute waits for the replies of the
+// probes, all hops are probed at the same time.
+var TraceTimeout = 3 * time.Second
+
+// DiagnoseTimeout is the maximum time of all the diagnostics, the alert
+// waits for them.
+var DiagnoseTimeout = 10 * time.Second
+
+// diagnosePorts are the default tcp ports of the schemes, the ones
+// without port aren't connected.
+var diagnosePorts = map[string]string{
+	"http":          "80",
+	"https":         "443",
+	"metrics+http":  "80",
+	"metrics+https": "443",
+	"ws":            "80",
+	"wss":           "443",
+	"couch":         "5984",
+	"elasticsearch": "9200",
+	"opensearch":    "9200",
+	"mongodb":       "27017",
+	"mysql":         "3306",
+	"smtp":          "25",
+	"imap":          "143",
+	"imaps":         "993",
+	"ldap":          "389",
+	"ldaptls":       "636",
+	"ssh":           "22",
+	"sftp":          "22",
+	"ftp":           "21",
+	"ftps":          "21",
+	"amqp":          "5672",
+	"amqps":         "5671",
+	"tcp":           "",
+	"grpc":          "",
+	"grpcs":         "",
+	"dns":           "",
+	"udp":           "",
+	"ntp":           "",
+	"snmp":          "",
+	"domain":        "",
+	"dnsbl":         "",
+}
+
+// diagnoseTLS are the schemes over TLS.
+var diagnoseTLS = map[string]bool{
+	"https":         true,
+	"metrics+https": true,
+	"wss":           true,
+	"grpcs":         true,
+	"imaps":         true,
+	"ldaptls":       true,
+	"amqps":         true,
+}
+
+// Diagnose runs quick diagnostics of the host of the monitor url: the
+// DNS resolution, the tcp connection time to each address, the TLS
+// handshake and a traceroute to the first address. It returns the
+// report, empty if the scheme hasn't a host, like exec or file, or if
+// the key diagnose is false. All of it takes at most DiagnoseTimeout,
+// the steps not done by then are reported as such.
+// The traceroute sends udp probes and reads the ICMP replies, it needs
+// root or CAP_NET_RAW. The diagnostics connect directly, the key proxy
+// isn't used, and the report says so.
+func Diagnose(m *Monitor) string {
+	return diagnoseContext(context.Background(), m)
+}
+
+// diagnoseContext is Diagnose that stops when ctx is done.
+func diagnoseContext(ctx context.Context, m *Monitor) string {
+	u, err := url.Parse(m.Url)
+	if err != nil {
+		u, err = utilUrl.ParseWithSocket(m.Url)
+		if err != nil {
+			return ""
+		}
+	}
+	defPort, ok := diagnosePorts[u.Scheme]
+	if !ok {
+		return ""
+	}
+	opts := options(m, u)
+	if diagnose, err := opts.Bool("diagnose", true); err != nil || !diagnose {
+		return ""
+	}
+	host, port := u.Hostname(), u.Port()
+	if typ, addr, err := utilUrl.Socket(u.Host); err == nil {
+		if typ != "tcp" {
+			return ""
+		}
+		host, port, err = net.SplitHostPort(addr)
+		if err != nil {
+			host, port = addr, ""
+		}
+	}
+	if host == "" {
+		return ""
+	}
+	if port == "" {
+		port = defPort
+	}
+	d, err := dialer(m, opts)
+	if err != nil {
+		return err.Error()
+	}
+	buf := bytes.NewBuffer(nil)
+	if d.Proxy != nil {
+		fmt.Fprintf(buf, "Proxy: %v isn't used, the diagnostics connect directly\n", d.Proxy.Redacted())
+		d.Proxy = nil
+	}
+	d.Address = ""
+	ctx, cancel := context.WithTimeout(ctx, DiagnoseTimeout)
+	defer cancel()
+
+	start := time.Now()
+	ips, err := diagnoseLookup(ctx, host)
+	if err != nil {
+		fmt.Fprintf(buf, "DNS: %v failed in %v: %v\n", host, since(start), err)
+		return buf.String()
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	fmt.Fprintf(buf, "DNS: %v resolved in %v to %v\n", host, since(start), strings.Join(addrs, ", "))
+
+	reachable := ""
+	if port != "" {
+		fmt.Fprintf(buf, "\nTCP port %v:\n", port)
+		type connect struct {
+			d   time.Duration
+			err error
+		}
+		results := make([]chan connect, len(ips))
+		for i, ip := range ips {
+			results[i] = make(chan connect, 1)
+			go func(ip net.IP, ch chan connect) {
+				dd := *d
+				dd.Address = ip.String()
+				t := time.Now()
+				conn, err := dd.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+				if err == nil {
+					conn.Close()
+				}
+				ch <- connect{time.Since(t), err}
+			}(ip, results[i])
+		}
+		for i, ip := range ips {
+			r := <-results[i]
+			if r.err != nil {
+				fmt.Fprintf(buf, "  %v: failed in %v: %v\n", ip, r.d.Round(time.Microsecond), r.err)
+				continue
+			}
+			fmt.Fprintf(buf, "  %v: connected in %v\n", ip, r.d.Round(time.Microsecond))
+			if reachable == "" {
+				reachable = ip.String()
+			}
+		}
+	}
+
+	useTLS, _ := opts.Bool("tls", false)
+	if reachable != "" && (diagnoseTLS[u.Scheme] || useTLS) {
+		fmt.Fprintf(buf, "\nTLS with %v:\n%v", reachable, diagnoseTLSHandshake(ctx, d, opts, host, reachable, port))
+	}
+
+	fmt.Fprintf(buf, "\nTraceroute to %v:\n", ips[0])
+	hops, err := opts.Int("hops", 20)
+	if err != nil {
+		hops = 20
+	}
+	trace, err := traceroute(ctx, d, ips[0], hops)
+	if err != nil {
+		fmt.Fprintf(buf, "  %v\n", err)
+	} else {
+		buf.WriteString(trace)
+	}
+	return buf.String()
+}
+
+func since(t time.Time) time.Duration {
+	return time.Since(t).Round(time.Microsecond)
+}
+
+// diagnoseLookup resolves host with github.com/fcavani/net/dns.
+func diagnoseLookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	type lookup struct {
+		addrs []string
+		err   error
+	}
+	ch := make(chan lookup, 1)
+	go func() {
+		addrs, err := dns.LookupHost(host)
+		ch <- lookup{addrs, err}
+	}()
+	var l lookup
+	select {
+	case l = <-ch:
+	case <-ctx.Done():
+		return nil, e.New("timeout: %v", ctx.Err())
+	}
+	if l.err != nil {
+		return nil, e.Forward(l.err)
+	}
+	ips := make([]net.IP, 0, len(l.addrs))
+	for _, a := range l.addrs {
+		if ip := net.ParseIP(a); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, e.New("no address")
+	}
+	return ips, nil
+}
+
+// diagnoseTLSHandshake does the TLS handshake with the address and
+// describes the connection and the certificate. The certificate is
+// verified after the handshake, to describe it even if it is invalid.
+func diagnoseTLSHandshake(ctx context.Context, d *Dialer, opts Options, host, ip, port string) string {
+	conf, err := tlsConfig(opts, host)
+	if err != nil {
+		return "  " + err.Error() + "\n"
+	}
+	verify := !conf.InsecureSkipVerify
+	conf.InsecureSkipVerify = true
+	dd := *d
+	dd.Address = ip
+	t := time.Now()
+	raw, err := dd.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Sprintf("  connection failed in %v: %v\n", since(t), err)
+	}
+	defer raw.Close()
+	conn := tls.Client(raw, conf)
+	err = conn.HandshakeContext(ctx)
+	if err != nil {
+		return fmt.Sprintf("  handshake failed in %v: %v\n", since(t), err)
+	}
+	state := conn.ConnectionState()
+	s := fmt.Sprintf("  %v, %v in %v", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), since(t))
+	if state.NegotiatedProtocol != "" {
+		s += ", ALPN " + state.NegotiatedProtocol
+	}
+	s += "\n"
+	if len(state.PeerCertificates) == 0 {
+		return s + "  no certificate\n"
+	}
+	leaf := state.PeerCertificates[0]
+	s += fmt.Sprintf("  certificate %v, issued by %v, expires at %v (%.0f days)\n",
+		leaf.Subject, leaf.Issuer, leaf.NotAfter.Format("2006-01-02"), time.Until(leaf.NotAfter).Hours()/24)
+	if len(leaf.DNSNames) > 0 {
+		s += "  names: " + strings.Join(leaf.DNSNames, ", ") + "\n"
+	}
+	if !verify {
+		return s + "  verification: skipped, insecure is set\n"
+	}
+	inter := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		inter.AddCert(c)
+	}
+	name := conf.ServerName
+	if name == "" {
+		name = host
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: conf.RootCAs, Intermediates: inter})
+	if err != nil {
+		return s + "  verification: failed: " + err.Error() + "\n"
+	}
+	return s + "  verification: ok\n"
+}
+
+// traceBasePort is the first udp port of the traceroute probes, the
+// port of each probe is traceBasePort plus the ttl.
+const traceBasePort = 33434
+
+type traceHop struct {
+	from    net.IP
+	rtt     time.Duration
+	reached bool
+}
+
+// traceroute sends udp probes with ttl from 1 to hops at the same time
+// and reads the ICMP time exceeded and port unreachable replies, until
+// TraceTimeout or the deadline of ctx.
+func traceroute(ctx context.Context, d *Dialer, ip net.IP, hops int) (string, error) {
+	probe, cancel := context.WithTimeout(ctx, TraceTimeout)
+	defer cancel()
+	deadline, _ := probe.Deadline()
+	v6 := ip.To4() == nil
+	network, icmpNet, laddr := "udp4", "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, icmpNet, laddr = "udp6", "ip6:ipv6-icmp", "::"
+	}
+	if d.Source != nil {
+		laddr = d.Source.String()
+	}
+	icmp, err := net.ListenPacket(icmpNet, laddr)
+	if err != nil {
+		return "", e.Push(e.New(err), "traceroute needs root or CAP_NET_RAW")
+	}
+	defer icmp.Close()
+
+	sent := make([]time.Time, hops+1)
+	for ttl := 1; ttl <= hops; ttl++ {
+		nd := net.Dialer{Control: ttlControl(ttl, v6)}
+		if d.Source != nil {
+			nd.LocalAddr = &net.UDPAddr{IP: d.Source}
+		}
+		conn, err := nd.DialContext(probe, network, net.JoinHostPort(ip.String(), strconv.Itoa(traceBasePort+ttl)))
+		if err != nil {
+			return "", e.Forward(err)
+		}
+		sent[ttl] = time.Now()
+		conn.Write([]byte("monlite"))
+		conn.Close()
+	}
+
+	found := make(map[int]traceHop)
+	last := hops
+	icmp.SetReadDeadline(deadline)
+	buf := make([]byte, 1500)
+	for len(found) < last {
+		n, from, err := icmp.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		ttl, reached, ok := traceReply(buf[:n], ip, v6)
+		if !ok || ttl < 1 || ttl > hops {
+			continue
+		}
+		if _, dup := found[ttl]; dup {
+			continue
+		}
+		var src net.IP
+		if a, ok := from.(*net.IPAddr); ok {
+			src = a.IP
+		}
+		found[ttl] = traceHop{from: src, rtt: time.Since(sent[ttl]), reached: reached || src.Equal(ip)}
+		if found[ttl].reached && ttl < last {
+			last = ttl
+		}
+	}
+
+	lines := make([]string, 0, last)
+	for ttl := 1; ttl <= last; ttl++ {
+		h, ok := found[ttl]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("  %2d  *", ttl))
+			continue
+		}
+		name := h.from.String()
+		if names, err := net.DefaultResolver.LookupAddr(ctx, name); err == nil && len(names) > 0 {
+			name = strings.TrimSuffix(names[0], ".") + " (" + h.from.String() + ")"
+		}
+		lines = append(lines, fmt.Sprintf("  %2d  %v  %v", ttl, name, h.rtt.Round(time.Microsecond)))
+		if h.reached {
+			break
+		}
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// traceReply parses the ICMP message and returns the ttl of the probe
+// that caused it, from its destination port, and if the destination was
+// reached.
+func traceReply(msg []byte, dst net.IP, v6 bool) (ttl int, reached bool, ok bool) {
+	if len(msg) < 8 {
+		return 0, false, false
+	}
+	typ, code := msg[0], msg[1]
+	inner := msg[8:]
+	var udp []byte
+	if v6 {
+		// Time exceeded 3, port unreachable 1 with code 4.
+		if typ != 3 && !(typ == 1 && code == 4) {
+			return 0, false, false
+		}
+		if len(inner) < 48 || inner[6] != 17 || !net.IP(inner[24:40]).Equal(dst) {
+			return 0, false, false
+		}
+		udp = inner[40:]
+		reached = typ == 1
+	} else {
+		// Time exceeded 11, port unreachable 3 with code 3.
+		if typ != 11 && !(typ == 3 && code == 3) {
+			return 0, false, false
+		}
+		if len(inner) < 20 {
+			return 0, false, false
+		}
+		ihl := int(inner[0]&0x0f) * 4
+		if len(inner) < ihl+8 || inner[9] != 17 || !net.IP(inner[16:20]).Equal(dst) {
+			return 0, false, false
+		}
+		udp = inner[ihl:]
+		reached = typ == 3
+	}
+	if len(udp) < 4 {
+		return 0, false, false
+	}
+	return int(binary.BigEndian.Uint16(udp[2:4])) - traceBasePort, reached, true
+}
@@ -0,0 +1,366 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// Dialer opens the connections of the checkers, directly or through a
+// proxy. Only tcp connections use the proxy.
+type Dialer struct {
+	// Timeout of the connection, including the proxy handshake.
+	Timeout time.Duration
+	// Proxy is http://[user:password@]host:port or https:// for HTTP
+	// CONNECT, or socks5://[user:password@]host:port for SOCKS5, where
+	// the names are resolved by the proxy.
+	Proxy *url.URL
+	// Address, if not empty, replaces the host of the tcp and udp
+	// addresses.
+	Address string
+	// Source is the local ip of the tcp and udp connections, nil for
+	// any.
+	Source net.IP
+	// Interface, if not empty, is the name of the network interface
+	// that the tcp and udp connections are bound to. Only in Linux.
+	Interface string
+}
+
+// dialer creates the dialer of the monitor. The key proxy is the proxy
+// url, source is the local ip and interface the network interface. The
+// timeout is the budget of the monitor.
+func dialer(m *Monitor, opts Options) (*Dialer, error) {
+	d := &Dialer{
+		Timeout:   budget(m),
+		Address:   m.address,
+		Interface: opts.String("interface", ""),
+	}
+	if s := opts.String("source", ""); s != "" {
+		d.Source = net.ParseIP(s)
+		if d.Source == nil {
+			return nil, e.New("invalid source %v", s)
+		}
+	}
+	if p := opts.String("proxy", ""); p != "" {
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, e.Push(e.New(err), "invalid proxy")
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, e.New("invalid proxy scheme %v", u.Scheme)
+		}
+		if u.Port() == "" {
+			return nil, e.New("proxy without port")
+		}
+		d.Proxy = u
+	}
+	return d, nil
+}
+
+// Dial connects to the address on the network.
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address on the network using the
+// context.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	if d.Address != "" && (strings.HasPrefix(network, "tcp") || strings.HasPrefix(network, "udp")) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, e.New(err)
+		}
+		addr = net.JoinHostPort(d.Address, port)
+	}
+	var nd net.Dialer
+	switch {
+	case strings.HasPrefix(network, "tcp"):
+		if d.Source != nil {
+			nd.LocalAddr = &net.TCPAddr{IP: d.Source}
+		}
+		nd.Control = bindControl(d.Interface)
+	case strings.HasPrefix(network, "udp"):
+		if d.Source != nil {
+			nd.LocalAddr = &net.UDPAddr{IP: d.Source}
+		}
+		nd.Control = bindControl(d.Interface)
+	}
+	if d.Proxy == nil || !strings.HasPrefix(network, "tcp") {
+		conn, err := nd.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, e.New(err)
+		}
+		return conn, nil
+	}
+	conn, err := nd.DialContext(ctx, "tcp", d.Proxy.Host)
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't connect to the proxy")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	switch d.Proxy.Scheme {
+	case "https":
+		tconn := tls.Client(conn, &tls.Config{ServerName: d.Proxy.Hostname()})
+		err = tconn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, e.Push(e.New(err), "tls handshake with the proxy failed")
+		}
+		conn = tconn
+		fallthrough
+	case "http":
+		var tunnel net.Conn
+		tunnel, err = httpConnect(conn, d.Proxy, addr)
+		if err == nil {
+			conn = tunnel
+		}
+	default:
+		err = socks5Connect(conn, d.Proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, e.Forward(err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// DialTLS connects to the address and does the tls handshake.
+func (d *Dialer) DialTLS(network, addr string, conf *tls.Config) (*tls.Conn, error) {
+	conn, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if d.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.Timeout))
+	}
+	tconn := tls.Client(conn, conf)
+	err = tconn.Handshake()
+	if err != nil {
+		conn.Close()
+		return nil, e.Push(e.New(err), "tls handshake failed")
+	}
+	conn.SetDeadline(time.Time{})
+	return tconn, nil
+}
+
+// source describes the source of the connections of the keys source and
+// interface, empty if they aren't set.
+func source(opts Options) string {
+	src, iface := opts.String("source", ""), opts.String("interface", "")
+	switch {
+	case src != "" && iface != "":
+		return src + " on " + iface
+	case src != "":
+		return src
+	}
+	return iface
+}
+
+// Transport creates a http transport that uses the dialer. The http
+// proxies are used as http proxies, so http requests are forwarded
+// and https requests use CONNECT, unless the dialer has an Address,
+// then all requests use CONNECT to it.
+func (d *Dialer) Transport(conf *tls.Config) *http.Transport {
+	t := &http.Transport{
+		DialContext:       d.DialContext,
+		TLSClientConfig:   conf,
+		DisableKeepAlives: true,
+	}
+	if d.Proxy != nil && d.Address == "" && (d.Proxy.Scheme == "http" || d.Proxy.Scheme == "https") {
+		direct := &Dialer{Timeout: d.Timeout, Source: d.Source, Interface: d.Interface}
+		t.DialContext = direct.DialContext
+		t.Proxy = http.ProxyURL(d.Proxy)
+	}
+	return t
+}
+
+// bufferedConn returns first what was read by the bufio.Reader.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// httpConnect opens the tunnel to addr with the HTTP CONNECT method.
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		pass, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't send the CONNECT to the proxy")
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't read the CONNECT reply of the proxy")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, e.New("proxy refused the CONNECT to %v: %v", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: io.MultiReader(br, conn)}, nil
+	}
+	return conn, nil
+}
+
+// SOCKS5 reply codes, RFC 1928.
+var socks5Errors = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect opens the connection to addr with the SOCKS5 CONNECT
+// command, with the username and password authentication (RFC 1929) if
+// the proxy url has an user.
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return e.New(err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return e.New("invalid port %v", port)
+	}
+	methods := []byte{0}
+	if proxy.User != nil {
+		methods = []byte{2}
+	}
+	_, err = conn.Write(append([]byte{5, byte(len(methods))}, methods...))
+	if err != nil {
+		return e.New(err)
+	}
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return e.Push(e.New(err), "can't read the SOCKS5 greeting")
+	}
+	if reply[0] != 5 {
+		return e.New("proxy isn't SOCKS5")
+	}
+	switch reply[1] {
+	case 0:
+	case 2:
+		if proxy.User == nil {
+			return e.New("proxy requires authentication")
+		}
+		user := proxy.User.Username()
+		pass, _ := proxy.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return e.New("proxy user or password too long")
+		}
+		msg := []byte{1, byte(len(user))}
+		msg = append(msg, user...)
+		msg = append(msg, byte(len(pass)))
+		msg = append(msg, pass...)
+		_, err = conn.Write(msg)
+		if err != nil {
+			return e.New(err)
+		}
+		_, err = io.ReadFull(conn, reply)
+		if err != nil {
+			return e.Push(e.New(err), "can't read the SOCKS5 authentication reply")
+		}
+		if reply[1] != 0 {
+			return e.New("proxy authentication failed")
+		}
+	default:
+		return e.New("proxy doesn't accept the authentication methods")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return e.New("host name too long")
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 1)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(p))
+	_, err = conn.Write(req)
+	if err != nil {
+		return e.New(err)
+	}
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return e.Push(e.New(err), "can't read the SOCKS5 reply")
+	}
+	if header[1] != 0 {
+		msg, ok := socks5Errors[header[1]]
+		if !ok {
+			msg = "error " + strconv.Itoa(int(header[1]))
+		}
+		return e.New("proxy can't connect to %v: %v", addr, msg)
+	}
+	// Skip the bound address.
+	var n int
+	switch header[3] {
+	case 1:
+		n = 4
+	case 4:
+		n = 16
+	case 3:
+		l := make([]byte, 1)
+		_, err = io.ReadFull(conn, l)
+		if err != nil {
+			return e.New(err)
+		}
+		n = int(l[0])
+	default:
+		return e.New("invalid SOCKS5 reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+	if err != nil {
+		return e.New(err)
+	}
+	return nil
+}
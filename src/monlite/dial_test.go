@@ -0,0 +1,405 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// fakeProxy is a HTTP CONNECT or SOCKS5 proxy that resolves the name
+// backend.test to 127.0.0.1 and records the addresses asked by the
+// clients.
+type fakeProxy struct {
+	scheme  string
+	ln      net.Listener
+	mutex   sync.Mutex
+	targets []string
+}
+
+func newFakeProxy(t *testing.T, scheme string) *fakeProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	p := &fakeProxy{scheme: scheme, ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+// url returns the url of the proxy with the user and password.
+func (p *fakeProxy) url(pass string) string {
+	return p.scheme + "://monitor:" + pass + "@" + p.ln.Addr().String()
+}
+
+// seen returns the addresses asked to the proxy.
+func (p *fakeProxy) seen() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *fakeProxy) dial(addr string) (net.Conn, error) {
+	p.mutex.Lock()
+	p.targets = append(p.targets, addr)
+	p.mutex.Unlock()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "backend.test" {
+		host = "127.0.0.1"
+	}
+	return net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second)
+}
+
+func (p *fakeProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	var backend net.Conn
+	var client io.Reader = conn
+	if p.scheme == "http" {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if req.Method != "CONNECT" {
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+			return
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte("monitor:secret"))
+		if req.Header.Get("Proxy-Authorization") != "Basic "+auth {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		backend, err = p.dial(req.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		client = br
+	} else {
+		var err error
+		backend, err = p.socks5(conn)
+		if err != nil {
+			return
+		}
+	}
+	defer backend.Close()
+	go func() {
+		io.Copy(backend, client)
+		backend.Close()
+	}()
+	io.Copy(conn, backend)
+}
+
+// socks5 does the server side of the SOCKS5 handshake, with the
+// username and password authentication, and connects to the address.
+func (p *fakeProxy) socks5(conn net.Conn) (net.Conn, error) {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, buf[1])); err != nil {
+		return nil, err
+	}
+	conn.Write([]byte{5, 2})
+	read := func() string {
+		l := make([]byte, 1)
+		io.ReadFull(conn, l)
+		s := make([]byte, l[0])
+		io.ReadFull(conn, s)
+		return string(s)
+	}
+	io.ReadFull(conn, make([]byte, 1))
+	if user, pass := read(), read(); user != "monitor" || pass != "secret" {
+		conn.Write([]byte{1, 1})
+		return nil, e.New("authentication failed")
+	}
+	conn.Write([]byte{1, 0})
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	var host string
+	switch header[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		host = read()
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, e.New("address type not supported")
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	backend, err := p.dial(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	return backend, nil
+}
+
+// fakeServer accepts the connections and runs serve with each one. It
+// returns the port.
+func fakeServer(t *testing.T, serve func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				serve(conn)
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func echoServer(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+// smtpServer answers 250 to all commands but QUIT.
+func smtpServer(conn net.Conn) {
+	io.WriteString(conn, "220 backend.test ESMTP\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(strings.ToUpper(line), "QUIT") {
+			io.WriteString(conn, "221 bye\r\n")
+			return
+		}
+		io.WriteString(conn, "250 backend.test\r\n")
+	}
+}
+
+// imapServer has the ID capability and answers OK to all commands.
+func imapServer(conn net.Conn) {
+	io.WriteString(conn, "* OK [CAPABILITY IMAP4rev1 ID] ready\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			return
+		}
+		switch strings.ToUpper(f[1]) {
+		case "CAPABILITY":
+			io.WriteString(conn, "* CAPABILITY IMAP4rev1 ID\r\n")
+		case "ID":
+			io.WriteString(conn, "* ID NIL\r\n")
+		case "LOGOUT":
+			io.WriteString(conn, "* BYE\r\n"+f[0]+" OK done\r\n")
+			return
+		}
+		io.WriteString(conn, f[0]+" OK done\r\n")
+	}
+}
+
+// mysqlServer accepts any user and answers the query of
+// max_allowed_packet.
+func mysqlServer(conn net.Conn) {
+	write := func(seq byte, data ...byte) {
+		h := []byte{byte(len(data)), byte(len(data) >> 8), byte(len(data) >> 16), seq}
+		conn.Write(append(h, data...))
+	}
+	read := func() []byte {
+		h := make([]byte, 4)
+		if _, err := io.ReadFull(conn, h); err != nil {
+			return nil
+		}
+		data := make([]byte, int(h[0])|int(h[1])<<8|int(h[2])<<16)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return nil
+		}
+		return data
+	}
+	ok := []byte{0, 0, 0, 2, 0, 0, 0}
+	eof := []byte{0xfe, 0, 0, 2, 0}
+	handshake := []byte{10}
+	handshake = append(handshake, "5.7.0\x00"...)
+	handshake = append(handshake, 1, 0, 0, 0)
+	handshake = append(handshake, "abcdefgh\x00"...)
+	// protocol 41 and secure connection
+	handshake = append(handshake, 0x00, 0x82, 33, 2, 0, 0, 0, 21)
+	handshake = append(handshake, make([]byte, 10)...)
+	handshake = append(handshake, "ijklmnopqrst\x00"...)
+	write(0, handshake...)
+	if read() == nil {
+		return
+	}
+	write(2, ok...)
+	for {
+		cmd := read()
+		if len(cmd) == 0 {
+			return
+		}
+		switch cmd[0] {
+		case 1: // quit
+			return
+		case 3: // query
+			write(1, 1)
+			write(2, 3, 'd', 'e', 'f')
+			write(3, eof...)
+			write(4, append([]byte{7}, "4194304"...)...)
+			write(5, eof...)
+		default:
+			write(1, ok...)
+		}
+	}
+}
+
+func TestDialProxy(t *testing.T) {
+	port := fakeServer(t, echoServer)
+	for _, scheme := range []string{"http", "socks5"} {
+		t.Run(scheme, func(t *testing.T) {
+			p := newFakeProxy(t, scheme)
+			proxy, err := url.Parse(p.url("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := &Dialer{Timeout: 5 * time.Second, Proxy: proxy}
+			conn, err := d.Dial("tcp", "backend.test:"+port)
+			if err != nil {
+				t.Fatal(e.Trace(err))
+			}
+			defer conn.Close()
+			io.WriteString(conn, "ping\n")
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || line != "ping\n" {
+				t.Fatalf("echo %q %v", line, err)
+			}
+			if targets := p.seen(); len(targets) != 1 || targets[0] != "backend.test:"+port {
+				t.Fatalf("the proxy connected to %v, expected backend.test:%v", targets, port)
+			}
+
+			wrong, _ := url.Parse(p.url("wrong"))
+			_, err = (&Dialer{Timeout: 5 * time.Second, Proxy: wrong}).Dial("tcp", "backend.test:"+port)
+			if err == nil || !strings.Contains(e.Trace(err), map[string]string{"http": "407", "socks5": "proxy authentication failed"}[scheme]) {
+				t.Fatalf("expected the authentication to fail, got %v", err)
+			}
+
+			// A port that nobody listens.
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, closed, _ := net.SplitHostPort(ln.Addr().String())
+			ln.Close()
+			_, err = d.Dial("tcp", "backend.test:"+closed)
+			if err == nil || !strings.Contains(e.Trace(err), map[string]string{"http": "502", "socks5": "connection refused"}[scheme]) {
+				t.Fatalf("expected the connection to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPConnectBuffered(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		req, err := http.ReadRequest(bufio.NewReader(server))
+		if err != nil || req.Host != "backend.test:25" {
+			return
+		}
+		// The reply and the greeting of the server in one write.
+		io.WriteString(server, "HTTP/1.1 200 OK\r\n\r\n220 ready\r\n")
+	}()
+	proxy, _ := url.Parse("http://127.0.0.1:3128")
+	conn, err := httpConnect(client, proxy, "backend.test:25")
+	if err != nil {
+		t.Fatal(e.Trace(err))
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "220 ready\r\n" {
+		t.Fatalf("greeting %q %v", line, err)
+	}
+}
+
+func TestCheckProxy(t *testing.T) {
+	servers := []struct {
+		scheme string
+		serve  func(conn net.Conn)
+		user   string
+	}{
+		{"tcp", echoServer, ""},
+		{"smtp", smtpServer, ""},
+		{"imap", imapServer, "monitor:secret@"},
+		{"mysql", mysqlServer, "monitor:secret@"},
+	}
+	for _, proxy := range []string{"http", "socks5"} {
+		for _, s := range servers {
+			t.Run(s.scheme+" over "+proxy, func(t *testing.T) {
+				p := newFakeProxy(t, proxy)
+				port := fakeServer(t, s.serve)
+				m := &Monitor{
+					Name:    s.scheme + " over " + proxy,
+					Url:     s.scheme + "://" + s.user + "backend.test:" + port,
+					Timeout: 5 * time.Second,
+					Options: Options{"proxy": p.url("secret")},
+				}
+				if s.scheme == "mysql" {
+					m.Url += "/db"
+				}
+				_, err := check(m)
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+				targets := p.seen()
+				if len(targets) == 0 {
+					t.Fatal("the check didn't use the proxy")
+				}
+				for _, target := range targets {
+					if target != "backend.test:"+port {
+						t.Fatalf("the proxy connected to %v, expected backend.test:%v", target, port)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/net/dns"
+	mdns "github.com/miekg/dns"
+)
+
+// dnsblQuery returns the name to query in the list for the ip: the
+// octets of ipv4 or the nibbles of ipv6 in reverse order.
+func dnsblQuery(ip net.IP, list string) string {
+	parts := make([]string, 0, 32)
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprint(ip4[i]))
+		}
+	} else {
+		ip6 := ip.To16()
+		for i := len(ip6) - 1; i >= 0; i-- {
+			parts = append(parts, fmt.Sprintf("%x", ip6[i]&0xf), fmt.Sprintf("%x", ip6[i]>>4))
+		}
+	}
+	return strings.Join(parts, ".") + "." + strings.Trim(list, ".")
+}
+
+// dnsblServers returns the name servers with port, the ones of
+// /etc/resolv.conf if servers is empty.
+func dnsblServers(servers []string) ([]string, error) {
+	port := "53"
+	if len(servers) == 0 {
+		config, err := mdns.ClientConfigFromFile(dns.ConfigurationFile)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		servers, port = config.Servers, config.Port
+	}
+	addrs := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, port)
+		}
+		addrs = append(addrs, s)
+	}
+	if len(addrs) == 0 {
+		return nil, e.New("no name servers")
+	}
+	return addrs, nil
+}
+
+// dnsblExchange sends the message to the name server and returns the
+// reply, before the deadline.
+func dnsblExchange(msg *mdns.Msg, server string, deadline time.Time) (*mdns.Msg, error) {
+	d := &net.Dialer{Deadline: deadline}
+	conn, err := d.Dial("udp", server)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	co := &mdns.Conn{Conn: conn}
+	defer co.Close()
+	co.SetDeadline(deadline)
+	if err := co.WriteMsg(msg); err != nil {
+		return nil, e.Forward(err)
+	}
+	r, err := co.ReadMsg()
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if r.Id != msg.Id {
+		return nil, e.New("id mismatch")
+	}
+	return r, nil
+}
+
+// dnsblLookup queries the list for the ip and returns the 127.0.0.x
+// codes, none if the ip isn't listed. Only NXDOMAIN means not listed,
+// the other failures, like SERVFAIL or REFUSED, are errors. The name
+// servers are tried in order until the deadline.
+func dnsblLookup(ip net.IP, list string, servers []string, deadline time.Time) ([]string, error) {
+	servers, err := dnsblServers(servers)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	query := dnsblQuery(ip, list)
+	msg := new(mdns.Msg)
+	msg.SetQuestion(mdns.Fqdn(query), mdns.TypeA)
+	var r *mdns.Msg
+	for _, s := range servers {
+		r, err = dnsblExchange(msg, s, deadline)
+		if err == nil || !time.Now().Before(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, e.Push(err, "query "+query+" failed")
+	}
+	switch r.Rcode {
+	case mdns.RcodeNameError:
+		return nil, nil
+	case mdns.RcodeSuccess:
+	default:
+		return nil, e.New("%v answered %v", list, mdns.RcodeToString[r.Rcode])
+	}
+	codes := make([]string, 0, len(r.Answer))
+	for _, rr := range r.Answer {
+		a, ok := rr.(*mdns.A)
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(a.A.String(), "127.0.0."):
+			codes = append(codes, a.A.String())
+		case strings.HasPrefix(a.A.String(), "127.255.255."):
+			// Spamhaus and others answer errors in this range, like
+			// queries from open resolvers.
+			return nil, e.New("%v refused the query with %v", list, a.A)
+		}
+	}
+	return codes, nil
+}
+
+type dnsblResult struct {
+	ip    net.IP
+	list  string
+	codes []string
+	err   error
+}
+
+// CheckDNSBL checks if the ip, or the addresses of the host, in
+// dnsbl://ip is listed in the DNS blacklists of the comma separated key
+// lists. Any 127.0.0.x answer means listed and NXDOMAIN not listed,
+// other answers make the result unknown. The key servers is a comma
+// separated list of name servers, host or host:port, instead of the
+// ones of /etc/resolv.conf. The resolution of the host and the queries
+// share the budget of the monitor.
+func CheckDNSBL(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	lists := opts.List("lists")
+	if len(lists) == 0 {
+		return nil, e.New("no lists to check")
+	}
+	servers := opts.List("servers")
+	host := url.Hostname()
+	if host == "" {
+		return nil, e.New("no ip to check")
+	}
+	deadline := time.Now().Add(budget(m))
+	ips := make([]net.IP, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := lookupHost(host, deadline)
+		if err != nil {
+			return nil, e.Push(err, "can't resolve "+host)
+		}
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	start := time.Now()
+	ch := make(chan dnsblResult, len(ips)*len(lists))
+	for _, ip := range ips {
+		for _, list := range lists {
+			go func(ip net.IP, list string) {
+				codes, err := dnsblLookup(ip, list, servers, deadline)
+				ch <- dnsblResult{ip, list, codes, err}
+			}(ip, list)
+		}
+	}
+	results := make(map[string]dnsblResult, cap(ch))
+	for i := 0; i < cap(ch); i++ {
+		r := <-ch
+		results[r.ip.String()+" "+r.list] = r
+	}
+
+	res := &Result{}
+	lines := make([]string, 0, cap(ch))
+	listed := make([]string, 0)
+	var failed error
+	for _, ip := range ips {
+		for _, list := range lists {
+			r := results[ip.String()+" "+list]
+			switch {
+			case r.err != nil:
+				lines = append(lines, fmt.Sprintf("%v in %v: %v", ip, list, r.err))
+				if failed == nil {
+					failed = r.err
+				}
+			case len(r.codes) > 0:
+				s := fmt.Sprintf("%v listed in %v (%v)", ip, list, strings.Join(r.codes, ", "))
+				lines = append(lines, s)
+				listed = append(listed, s)
+			default:
+				lines = append(lines, fmt.Sprintf("%v not listed in %v", ip, list))
+			}
+		}
+	}
+	res.Perf = []Perf{
+		{Label: "listed", Value: float64(len(listed)), Min: "0"},
+		{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"},
+	}
+	res.Detail = strings.Join(lines, "\n")
+	if len(listed) > 0 {
+		res.State = StateCritical
+		res.Summary = strings.Join(listed, ", ")
+		return res, e.New("%v", res.Summary)
+	}
+	if failed != nil {
+		res.State = StateUnknown
+		res.Summary = "some lists couldn't be checked"
+		return res, e.Push(failed, res.Summary)
+	}
+	res.Summary = fmt.Sprintf("%v not listed in %v lists", host, len(lists))
+	return res, nil
+}
+
+func init() {
+	Add("dnsbl", CheckDNSBL)
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	mdns "github.com/miekg/dns"
+)
+
+func TestDnsblQuery(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.99", "99.2.0.192.zen.spamhaus.org"},
+		{"2001:db8:1:2::3", "3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.2.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2.zen.spamhaus.org"},
+	}
+	for _, tt := range tests {
+		if got := dnsblQuery(net.ParseIP(tt.ip), "zen.spamhaus.org."); got != tt.want {
+			t.Errorf("dnsblQuery(%v) = %v, expected %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// fakeDNSBL answers the queries by the list name: listed.test has the
+// ip 127.0.0.2, clean.test is NXDOMAIN, broken.test SERVFAIL, refused.test
+// REFUSED and open.test 127.255.255.254.
+func fakeDNSBL(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := new(mdns.Msg)
+			if req.Unpack(buf[:n]) != nil || len(req.Question) != 1 {
+				continue
+			}
+			q := req.Question[0]
+			resp := new(mdns.Msg)
+			resp.SetReply(req)
+			answer := func(ip string) {
+				resp.Answer = append(resp.Answer, &mdns.A{
+					Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip),
+				})
+			}
+			switch {
+			case strings.HasSuffix(q.Name, ".listed.test."):
+				answer("127.0.0.2")
+			case strings.HasSuffix(q.Name, ".clean.test."):
+				resp.Rcode = mdns.RcodeNameError
+			case strings.HasSuffix(q.Name, ".refused.test."):
+				resp.Rcode = mdns.RcodeRefused
+			case strings.HasSuffix(q.Name, ".open.test."):
+				answer("127.255.255.254")
+			default:
+				resp.Rcode = mdns.RcodeServerFailure
+			}
+			out, err := resp.Pack()
+			if err == nil {
+				conn.WriteTo(out, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestCheckDNSBL(t *testing.T) {
+	server := fakeDNSBL(t)
+	tests := []struct {
+		lists string
+		state State
+		err   string
+	}{
+		{"clean.test", StateOk, ""},
+		{"clean.test,listed.test", StateCritical, "listed in listed.test (127.0.0.2)"},
+		{"clean.test,broken.test", StateUnknown, "broken.test answered SERVFAIL"},
+		{"refused.test", StateUnknown, "refused.test answered REFUSED"},
+		{"open.test", StateUnknown, "refused the query with 127.255.255.254"},
+		{"broken.test,listed.test", StateCritical, "listed in listed.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lists, func(t *testing.T) {
+			m := &Monitor{
+				Name:    "dnsbl " + tt.lists,
+				Url:     "dnsbl://192.0.2.99",
+				Timeout: 5 * time.Second,
+				Options: Options{"lists": tt.lists, "servers": server},
+			}
+			res, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+			} else if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+			if res == nil || res.State != tt.state {
+				t.Fatalf("state of %v, expected %v", res, tt.state)
+			}
+		})
+	}
+}
+
+func TestCheckDNSBLDeadline(t *testing.T) {
+	// A name server that never answers, tried twice.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	silent := conn.LocalAddr().String()
+	m := &Monitor{
+		Name:    "dnsbl deadline",
+		Url:     "dnsbl://192.0.2.99",
+		Timeout: time.Second,
+		Options: Options{"lists": "clean.test", "servers": silent + "," + silent},
+	}
+	start := time.Now()
+	res, err := check(m)
+	if err == nil || res == nil || res.State != StateUnknown {
+		t.Fatalf("expected a timeout, got %v %v", res, err)
+	}
+	if elapsed := time.Since(start); elapsed > m.Timeout {
+		t.Fatalf("the queries took %v, more than the timeout of %v", elapsed, m.Timeout)
+	}
+}
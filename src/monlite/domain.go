@@ -0,0 +1,416 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// RDAPBootstrap is the IANA registry of the RDAP servers of the TLDs.
+var RDAPBootstrap = "https://data.iana.org/rdap/dns.json"
+
+// WhoisIANA is the whois server that knows the whois servers of the
+// TLDs.
+var WhoisIANA = "whois.iana.org"
+
+// registration is what the registry knows about a domain.
+type registration struct {
+	expiry      time.Time
+	registrar   string
+	nameservers []string
+	source      string
+}
+
+var domainCache = struct {
+	sync.Mutex
+	rdap    map[string]string
+	fetched time.Time
+	whois   map[string]string
+}{whois: make(map[string]string)}
+
+func domainGet(ctx context.Context, client *http.Client, rawurl string, accept string, v interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawurl, nil)
+	if err != nil {
+		return 0, e.New(err)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, e.Push(e.New(err), "get "+rawurl+" failed")
+	}
+	defer resp.Body.Close()
+	body, err := httpBody(resp)
+	if err != nil {
+		return resp.StatusCode, e.Forward(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, e.New("get %v returned %v", rawurl, resp.Status)
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return resp.StatusCode, e.Push(e.New(err), "invalid response of "+rawurl)
+	}
+	return resp.StatusCode, nil
+}
+
+// rdapServer returns the RDAP base url of the tld, from the IANA
+// bootstrap that is fetched once a day. It is empty if the tld hasn't
+// RDAP.
+func rdapServer(ctx context.Context, client *http.Client, tld string) (string, error) {
+	domainCache.Lock()
+	defer domainCache.Unlock()
+	if domainCache.rdap == nil || time.Since(domainCache.fetched) > 24*time.Hour {
+		var bootstrap struct {
+			Services [][][]string `json:"services"`
+		}
+		_, err := domainGet(ctx, client, RDAPBootstrap, "application/json", &bootstrap)
+		if err != nil {
+			return "", e.Push(err, "can't get the rdap bootstrap")
+		}
+		servers := make(map[string]string)
+		for _, s := range bootstrap.Services {
+			if len(s) != 2 || len(s[1]) == 0 {
+				continue
+			}
+			base := s[1][0]
+			for _, u := range s[1] {
+				if strings.HasPrefix(u, "https://") {
+					base = u
+					break
+				}
+			}
+			for _, t := range s[0] {
+				servers[strings.ToLower(t)] = base
+			}
+		}
+		domainCache.rdap = servers
+		domainCache.fetched = time.Now()
+	}
+	return domainCache.rdap[tld], nil
+}
+
+// rdapLookup gets the registration of the domain from the RDAP server
+// base.
+func rdapLookup(ctx context.Context, client *http.Client, base, domain string) (*registration, error) {
+	var data struct {
+		Events []struct {
+			Action string `json:"eventAction"`
+			Date   string `json:"eventDate"`
+		} `json:"events"`
+		Entities []struct {
+			Roles []string      `json:"roles"`
+			Vcard []interface{} `json:"vcardArray"`
+		} `json:"entities"`
+		Nameservers []struct {
+			Name string `json:"ldhName"`
+		} `json:"nameservers"`
+	}
+	rawurl := strings.TrimSuffix(base, "/") + "/domain/" + domain
+	code, err := domainGet(ctx, client, rawurl, "application/rdap+json", &data)
+	if code == http.StatusNotFound {
+		return nil, e.New("domain %v isn't registered", domain)
+	} else if err != nil {
+		return nil, e.Forward(err)
+	}
+	reg := &registration{source: rawurl}
+	for _, ev := range data.Events {
+		if ev.Action != "expiration" {
+			continue
+		}
+		reg.expiry, err = time.Parse(time.RFC3339, ev.Date)
+		if err != nil {
+			return nil, e.New("invalid expiration date %v", ev.Date)
+		}
+	}
+	for _, ent := range data.Entities {
+		for _, role := range ent.Roles {
+			if role == "registrar" {
+				reg.registrar = vcardName(ent.Vcard)
+			}
+		}
+	}
+	for _, ns := range data.Nameservers {
+		reg.nameservers = append(reg.nameservers, ns.Name)
+	}
+	return reg, nil
+}
+
+// vcardName returns the fn property of the jCard.
+func vcardName(vcard []interface{}) string {
+	if len(vcard) != 2 {
+		return ""
+	}
+	props, ok := vcard[1].([]interface{})
+	if !ok {
+		return ""
+	}
+	for _, p := range props {
+		prop, ok := p.([]interface{})
+		if !ok || len(prop) < 4 || prop[0] != "fn" {
+			continue
+		}
+		if s, ok := prop[3].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// whoisQuery sends the query to the whois server and returns the reply,
+// before the deadline of ctx.
+func whoisQuery(ctx context.Context, d *Dialer, server, query string) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "43")
+	}
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	_, err = conn.Write([]byte(query + "\r\n"))
+	if err != nil {
+		return nil, e.New(err)
+	}
+	reply, err := ioutil.ReadAll(io.LimitReader(conn, MaxHTTPBody))
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't read the reply of "+server)
+	}
+	return reply, nil
+}
+
+// whoisFields parses the key: value lines of a whois reply, the keys
+// in lower case.
+func whoisFields(reply []byte) map[string][]string {
+	fields := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(reply))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '%' || line[0] == '#' || line[0] == '>' {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			continue
+		}
+		k := strings.ToLower(strings.TrimSpace(line[:i]))
+		v := strings.TrimSpace(line[i+1:])
+		if v != "" {
+			fields[k] = append(fields[k], v)
+		}
+	}
+	return fields
+}
+
+// whoisServer returns the whois server of the tld, asking WhoisIANA.
+func whoisServer(ctx context.Context, d *Dialer, tld string) (string, error) {
+	domainCache.Lock()
+	server, ok := domainCache.whois[tld]
+	domainCache.Unlock()
+	if ok {
+		return server, nil
+	}
+	reply, err := whoisQuery(ctx, d, WhoisIANA, tld)
+	if err != nil {
+		return "", e.Forward(err)
+	}
+	servers := whoisFields(reply)["whois"]
+	if len(servers) == 0 {
+		return "", e.New("tld %v has no whois server", tld)
+	}
+	domainCache.Lock()
+	domainCache.whois[tld] = servers[0]
+	domainCache.Unlock()
+	return servers[0], nil
+}
+
+var (
+	whoisExpiryKeys    = []string{"registry expiry date", "registrar registration expiration date", "expiration date", "expiry date", "expires", "expire", "expires on", "paid-till", "renewal date", "valid until"}
+	whoisRegistrarKeys = []string{"registrar", "registrar name", "sponsoring registrar"}
+	whoisNSKeys        = []string{"name server", "nserver", "nameserver", "nameservers"}
+	whoisDateFormats   = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+		"2006.01.02",
+		"2006/01/02",
+		"02-Jan-2006",
+		"02.01.2006",
+		"January 2 2006",
+	}
+)
+
+func whoisDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "UTC"))
+	for _, f := range whoisDateFormats {
+		t, err := time.Parse(f, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	// Some add the time or the zone after the date.
+	if i := strings.IndexAny(s, " T"); i > 0 {
+		return whoisDate(s[:i])
+	}
+	return time.Time{}, e.New("invalid date %v", s)
+}
+
+// whoisLookup gets the registration of the domain from the whois
+// server.
+func whoisLookup(ctx context.Context, d *Dialer, server, domain string) (*registration, error) {
+	reply, err := whoisQuery(ctx, d, server, domain)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	fields := whoisFields(reply)
+	first := func(keys []string) []string {
+		for _, k := range keys {
+			if v, ok := fields[k]; ok {
+				return v
+			}
+		}
+		return nil
+	}
+	reg := &registration{source: "whois " + server}
+	v := first(whoisExpiryKeys)
+	if len(v) == 0 {
+		return nil, e.New("no expiration date in the reply of %v: %v", server, snippet(reply))
+	}
+	reg.expiry, err = whoisDate(v[0])
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if v := first(whoisRegistrarKeys); len(v) > 0 {
+		reg.registrar = v[0]
+	}
+	for _, ns := range first(whoisNSKeys) {
+		reg.nameservers = append(reg.nameservers, strings.Fields(ns)[0])
+	}
+	return reg, nil
+}
+
+// normalizeNS lower cases the names, removes the final dots and sorts.
+func normalizeNS(names []string) []string {
+	ns := make([]string, 0, len(names))
+	for _, n := range names {
+		if n = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(n), ".")); n != "" {
+			ns = append(ns, n)
+		}
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// CheckDomain checks the registration of domain://example.com. The
+// expiration date comes from the RDAP server of the TLD, from the IANA
+// bootstrap, or from the whois server of the TLD if it hasn't RDAP or
+// RDAP failed. All the queries share the budget of the monitor. The
+// keys are:
+//
+//	days        warns if the domain expires in less than days (30).
+//	nameservers comma separated list of the expected name servers.
+//	rdap        RDAP base url, instead of the bootstrap.
+//	whois       whois server, instead of the one of the TLD.
+func CheckDomain(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	domain := strings.ToLower(strings.TrimSuffix(url.Hostname(), "."))
+	i := strings.LastIndex(domain, ".")
+	if i <= 0 {
+		return nil, e.New("invalid domain %v", domain)
+	}
+	tld := domain[i+1:]
+	days, err := opts.Int("days", 30)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	client := &http.Client{Transport: d.Transport(nil)}
+	ctx, cancel := context.WithTimeout(context.Background(), budget(m))
+	defer cancel()
+
+	var reg *registration
+	var rdapErr error
+	base := opts.String("rdap", "")
+	if base == "" && opts.String("whois", "") == "" {
+		base, rdapErr = rdapServer(ctx, client, tld)
+	}
+	if base != "" {
+		reg, rdapErr = rdapLookup(ctx, client, base, domain)
+		if e.Contains(rdapErr, "isn't registered") {
+			return nil, e.Forward(rdapErr)
+		}
+	}
+	if reg == nil {
+		server := opts.String("whois", "")
+		if server == "" {
+			server, err = whoisServer(ctx, d, tld)
+			if err != nil {
+				if rdapErr != nil {
+					err = e.Push(err, rdapErr.Error())
+				}
+				return nil, e.Forward(err)
+			}
+		}
+		reg, err = whoisLookup(ctx, d, server, domain)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+	}
+	if reg.expiry.IsZero() {
+		return nil, e.New("%v has no expiration date", reg.source)
+	}
+
+	left := time.Until(reg.expiry)
+	ns := normalizeNS(reg.nameservers)
+	res := &Result{
+		Summary: fmt.Sprintf("%v expires in %.0f days, at %v", domain, left.Hours()/24, reg.expiry.Format("2006-01-02")),
+		Perf:    []Perf{{Label: "expiry", Value: round(left.Hours() / 24), Unit: "d", Warn: fmt.Sprint(days), Crit: "0"}},
+		Detail: fmt.Sprintf("registrar: %v\nnameservers: %v\nsource: %v",
+			reg.registrar, strings.Join(ns, ", "), reg.source),
+	}
+	if reg.registrar != "" {
+		res.Summary += ", registrar " + reg.registrar
+	}
+	if left <= 0 {
+		res.State = StateCritical
+		return res, e.New("domain %v expired at %v", domain, reg.expiry.Format("2006-01-02"))
+	}
+	if expected := normalizeNS(opts.List("nameservers")); len(expected) > 0 {
+		if strings.Join(expected, ",") != strings.Join(ns, ",") {
+			res.State = StateCritical
+			return res, e.New("name servers are %v, expected %v", strings.Join(ns, ", "), strings.Join(expected, ", "))
+		}
+	}
+	if left < time.Duration(days)*24*time.Hour {
+		res.State = StateWarning
+		return res, e.New("domain %v expires in %.1f days", domain, left.Hours()/24)
+	}
+	return res, nil
+}
+
+func init() {
+	Add("domain", CheckDomain)
+}
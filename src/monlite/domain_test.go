@@ -0,0 +1,124 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWhoisDate(t *testing.T) {
+	day := time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+		fail bool
+	}{
+		{"2024-08-13T04:00:00Z", day.Add(4 * time.Hour), false},
+		{"2024-08-13T04:00:00.000Z", day.Add(4 * time.Hour), false},
+		{"2024-08-13T04:00:00", day.Add(4 * time.Hour), false},
+		{"2024-08-13 04:00:00 UTC", day.Add(4 * time.Hour), false},
+		{" 2024-08-13 ", day, false},
+		{"2024.08.13", day, false},
+		{"2024/08/13", day, false},
+		{"13-Aug-2024", day, false},
+		{"13.08.2024", day, false},
+		{"August 13 2024", day, false},
+		{"2024.08.13 12:00:00 (UTC+8)", day, false},
+		{"2024-08-13T04:00:00+0200", day, false},
+		{"20240813", time.Time{}, true},
+		{"soon", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := whoisDate(tt.in)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("whoisDate(%q) = %v, expected an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("whoisDate(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("whoisDate(%q) = %v, expected %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWhoisFields(t *testing.T) {
+	reply := []byte("% IANA WHOIS server\r\n" +
+		"# comment: ignored\n" +
+		">>> Last update of WHOIS database: 2024-08-13T04:00:00Z <<<\n" +
+		"Domain Name: EXAMPLE.COM\n" +
+		"Registry Expiry Date: 2024-08-13T04:00:00Z\n" +
+		"Name Server: A.IANA-SERVERS.NET\n" +
+		"Name Server: B.IANA-SERVERS.NET\n" +
+		"Empty:\n" +
+		"no separator\n")
+	want := map[string][]string{
+		"domain name":          {"EXAMPLE.COM"},
+		"registry expiry date": {"2024-08-13T04:00:00Z"},
+		"name server":          {"A.IANA-SERVERS.NET", "B.IANA-SERVERS.NET"},
+	}
+	got := whoisFields(reply)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("whoisFields = %v, expected %v", got, want)
+	}
+}
+
+// slowWhois accepts the connections, reads the query and never answers.
+func slowWhois(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				bufio.NewReader(conn).ReadString('\n')
+				time.Sleep(5 * time.Second)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestCheckDomainDeadline(t *testing.T) {
+	rdap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer rdap.Close()
+	m := &Monitor{
+		Name:    "domain deadline",
+		Url:     "domain://example.com",
+		Timeout: time.Second,
+		Options: Options{"rdap": rdap.URL, "whois": slowWhois(t)},
+	}
+	start := time.Now()
+	_, err := check(m)
+	if err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > m.Timeout {
+		t.Fatalf("rdap and whois took %v, more than the timeout of %v", elapsed, m.Timeout)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	utilUrl "github.com/fcavani/net/url"
+)
+
+type esClient struct {
+	url    *url.URL
+	client *http.Client
+}
+
+func (c *esClient) get(path string, v interface{}) error {
+	u := utilUrl.Copy(c.url)
+	u.User = nil
+	if i := strings.Index(path, "?"); i >= 0 {
+		u.RawQuery = path[i+1:]
+		path = path[:i]
+	}
+	u.Path = path
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return e.New(err)
+	}
+	if c.url.User != nil {
+		pass, _ := c.url.User.Password()
+		req.SetBasicAuth(c.url.User.Username(), pass)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return e.Push(e.New(err), "get "+path+" failed")
+	}
+	defer resp.Body.Close()
+	body, err := httpBody(resp)
+	if err != nil {
+		return e.Forward(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return e.New("get %v returned %v: %v", path, resp.Status, snippet(body))
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return e.Push(e.New(err), "invalid response of "+path)
+	}
+	return nil
+}
+
+// esWatermark is a disk watermark: a percentage of used disk or, if
+// free isn't zero, the minimum free bytes.
+type esWatermark struct {
+	name    string
+	percent float64
+	free    int64
+}
+
+var esSizes = []struct {
+	suffix string
+	mult   int64
+}{
+	{"pb", 1 << 50}, {"tb", 1 << 40}, {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1},
+}
+
+// parseWatermark parses the values of the watermark settings: 85%, 0.85
+// or 500mb.
+func parseWatermark(name, s string) (esWatermark, error) {
+	w := esWatermark{name: name}
+	s = strings.ToLower(strings.TrimSpace(s))
+	if strings.HasSuffix(s, "%") {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return w, e.New("invalid watermark %v", s)
+		}
+		w.percent = f
+		return w, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		w.percent = f * 100
+		return w, nil
+	}
+	for _, size := range esSizes {
+		if strings.HasSuffix(s, size.suffix) {
+			f, err := strconv.ParseFloat(strings.TrimSuffix(s, size.suffix), 64)
+			if err != nil {
+				return w, e.New("invalid watermark %v", s)
+			}
+			w.free = int64(f * float64(size.mult))
+			return w, nil
+		}
+	}
+	return w, e.New("invalid watermark %v", s)
+}
+
+// exceeded returns true if the disk is above the watermark.
+func (w esWatermark) exceeded(percent float64, avail int64) bool {
+	if w.free > 0 {
+		return avail < w.free
+	}
+	return percent >= w.percent
+}
+
+// disk compares the disk usage of the nodes with the watermarks of the
+// cluster. It returns the worst state, the detail lines and the
+// performance data.
+func (c *esClient) disk() (State, []string, []Perf, error) {
+	var settings struct {
+		Persistent map[string]interface{} `json:"persistent"`
+		Transient  map[string]interface{} `json:"transient"`
+		Defaults   map[string]interface{} `json:"defaults"`
+	}
+	err := c.get("/_cluster/settings?include_defaults=true&flat_settings=true", &settings)
+	if err != nil {
+		return StateOk, nil, nil, e.Forward(err)
+	}
+	setting := func(key, def string) string {
+		for _, m := range []map[string]interface{}{settings.Transient, settings.Persistent, settings.Defaults} {
+			if v, ok := m[key].(string); ok {
+				return v
+			}
+		}
+		return def
+	}
+	const prefix = "cluster.routing.allocation.disk.watermark."
+	watermarks := make([]esWatermark, 0, 3)
+	for _, w := range []struct{ name, def string }{{"flood_stage", "95%"}, {"high", "90%"}, {"low", "85%"}} {
+		wm, err := parseWatermark(w.name, setting(prefix+w.name, w.def))
+		if err != nil {
+			return StateOk, nil, nil, e.Forward(err)
+		}
+		watermarks = append(watermarks, wm)
+	}
+
+	var nodes []struct {
+		Node    string `json:"node"`
+		Percent string `json:"disk.percent"`
+		Avail   string `json:"disk.avail"`
+	}
+	err = c.get("/_cat/allocation?format=json&bytes=b", &nodes)
+	if err != nil {
+		return StateOk, nil, nil, e.Forward(err)
+	}
+	state := StateOk
+	lines := make([]string, 0, len(nodes))
+	perfs := make([]Perf, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Node == "UNASSIGNED" || n.Percent == "" {
+			continue
+		}
+		percent, err := strconv.ParseFloat(n.Percent, 64)
+		if err != nil {
+			return StateOk, nil, nil, e.New("invalid disk percent %v of %v", n.Percent, n.Node)
+		}
+		avail, _ := strconv.ParseInt(n.Avail, 10, 64)
+		perfs = append(perfs, Perf{Label: "disk_" + n.Node, Value: percent, Unit: "%", Min: "0", Max: "100"})
+		for _, w := range watermarks {
+			if !w.exceeded(percent, avail) {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("node %v disk %v%% used is above the %v watermark", n.Node, percent, w.name))
+			switch {
+			case w.name == "flood_stage":
+				state = StateCritical
+			case w.name == "high" && state < StateWarning:
+				state = StateWarning
+			}
+			break
+		}
+	}
+	return state, lines, perfs, nil
+}
+
+// CheckElasticsearch checks the health of the Elasticsearch or
+// OpenSearch cluster in elasticsearch://[user:password@]host[:port],
+// the port is 9200 by default. If the key tls is true https is used,
+// with the TLS keys of tlsConfig. The status must be green, or yellow
+// if the key yellow is true. The optional keys are:
+//
+//	unassigned maximum number of unassigned shards.
+//	nodes      minimum number of nodes.
+//	pending    maximum number of pending tasks.
+//	disk       if true the disk usage of the nodes is compared with
+//	           the watermarks: above high is a warning and above
+//	           flood_stage is critical.
+func CheckElasticsearch(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	useTLS, err := opts.Bool("tls", false)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	yellow, err := opts.Bool("yellow", false)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	disk, err := opts.Bool("disk", false)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	u := utilUrl.Copy(url)
+	u.Scheme = "http"
+	u.RawQuery = ""
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "9200")
+	}
+	var conf *tls.Config
+	if useTLS {
+		u.Scheme = "https"
+		// Without servername each redirect is verified with its own
+		// host.
+		conf, err = tlsConfig(opts, "")
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+	}
+	c := &esClient{
+		url:    u,
+		client: &http.Client{Transport: d.Transport(conf), Timeout: budget(m)},
+	}
+
+	start := time.Now()
+	var health struct {
+		Cluster      string  `json:"cluster_name"`
+		Status       string  `json:"status"`
+		Nodes        int     `json:"number_of_nodes"`
+		Unassigned   int     `json:"unassigned_shards"`
+		Pending      int     `json:"number_of_pending_tasks"`
+		ActiveShards float64 `json:"active_shards_percent_as_number"`
+	}
+	err = c.get("/_cluster/health", &health)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	res := &Result{
+		Summary: fmt.Sprintf("cluster %v is %v with %v nodes", health.Cluster, health.Status, health.Nodes),
+		Perf: []Perf{
+			{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"},
+			{Label: "nodes", Value: float64(health.Nodes), Min: "0"},
+			{Label: "unassigned", Value: float64(health.Unassigned), Min: "0"},
+			{Label: "pending", Value: float64(health.Pending), Min: "0"},
+			{Label: "active_shards", Value: health.ActiveShards, Unit: "%", Min: "0", Max: "100"},
+		},
+	}
+	var failures []string
+	switch health.Status {
+	case "green":
+	case "yellow":
+		if !yellow {
+			failures = append(failures, "status is yellow")
+		}
+	default:
+		failures = append(failures, "status is "+health.Status)
+	}
+	limit := func(key string, value int, max bool) error {
+		if _, ok := opts[key]; !ok {
+			return nil
+		}
+		n, err := opts.Int(key, 0)
+		if err != nil {
+			return e.Forward(err)
+		}
+		if max && value > n {
+			failures = append(failures, fmt.Sprintf("%v %v, the maximum is %v", value, key, n))
+		} else if !max && value < n {
+			failures = append(failures, fmt.Sprintf("%v %v, the minimum is %v", value, key, n))
+		}
+		return nil
+	}
+	for _, l := range []struct {
+		key   string
+		value int
+		max   bool
+	}{{"unassigned", health.Unassigned, true}, {"nodes", health.Nodes, false}, {"pending", health.Pending, true}} {
+		err = limit(l.key, l.value, l.max)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+	}
+	if len(failures) > 0 {
+		res.State = StateCritical
+	}
+
+	if disk {
+		state, lines, perfs, err := c.disk()
+		if err != nil {
+			res.State = StateCritical
+			return res, e.Push(err, "can't check the disks")
+		}
+		res.Perf = append(res.Perf, perfs...)
+		res.Detail = strings.Join(lines, "\n")
+		if state > res.State {
+			res.State = state
+		}
+		if state != StateOk {
+			failures = append(failures, "disk watermark exceeded")
+		}
+	}
+	if len(failures) > 0 {
+		return res, e.New("%v", strings.Join(failures, ", "))
+	}
+	return res, nil
+}
+
+func init() {
+	Add("elasticsearch", CheckElasticsearch)
+	Add("opensearch", CheckElasticsearch)
+}
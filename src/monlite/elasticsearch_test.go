@@ -0,0 +1,178 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestParseWatermark(t *testing.T) {
+	tests := []struct {
+		in      string
+		percent float64
+		free    int64
+		fail    bool
+	}{
+		{"85%", 85, 0, false},
+		{" 90.5% ", 90.5, 0, false},
+		{"0.95", 95, 0, false},
+		{"500mb", 0, 500 << 20, false},
+		{"1.5GB", 0, 3 << 29, false},
+		{"10b", 0, 10, false},
+		{"2tb", 0, 2 << 40, false},
+		{"x%", 0, 0, true},
+		{"xmb", 0, 0, true},
+		{"lots", 0, 0, true},
+	}
+	for _, tt := range tests {
+		w, err := parseWatermark("high", tt.in)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("parseWatermark(%q) = %v, expected an error", tt.in, w)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseWatermark(%q): %v", tt.in, err)
+			continue
+		}
+		if w.percent != tt.percent || w.free != tt.free {
+			t.Errorf("parseWatermark(%q) = %v%% %v bytes, expected %v%% %v bytes", tt.in, w.percent, w.free, tt.percent, tt.free)
+		}
+	}
+}
+
+func TestWatermarkExceeded(t *testing.T) {
+	tests := []struct {
+		w       esWatermark
+		percent float64
+		avail   int64
+		want    bool
+	}{
+		{esWatermark{percent: 90}, 89.9, 0, false},
+		{esWatermark{percent: 90}, 90, 0, true},
+		{esWatermark{free: 1000}, 99, 1000, false},
+		{esWatermark{free: 1000}, 10, 999, true},
+	}
+	for _, tt := range tests {
+		if got := tt.w.exceeded(tt.percent, tt.avail); got != tt.want {
+			t.Errorf("%+v exceeded(%v, %v) = %v, expected %v", tt.w, tt.percent, tt.avail, got, tt.want)
+		}
+	}
+}
+
+// fakeElasticsearch answers the health, settings and allocation
+// requests with the canned bodies.
+func fakeElasticsearch(t *testing.T, health, settings, allocation string) string {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "monitor" || pass != "secret" {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		var body string
+		switch r.URL.Path {
+		case "/_cluster/health":
+			body = health
+		case "/_cluster/settings":
+			if r.URL.Query().Get("include_defaults") != "true" {
+				http.Error(w, "defaults not included", http.StatusBadRequest)
+				return
+			}
+			body = settings
+		case "/_cat/allocation":
+			if r.URL.Query().Get("bytes") != "b" {
+				http.Error(w, "bytes not in b", http.StatusBadRequest)
+				return
+			}
+			body = allocation
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func esHealth(status string, nodes, unassigned, pending int) string {
+	return `{"cluster_name":"search","status":"` + status + `","number_of_nodes":` + strconv.Itoa(nodes) +
+		`,"unassigned_shards":` + strconv.Itoa(unassigned) + `,"number_of_pending_tasks":` + strconv.Itoa(pending) +
+		`,"active_shards_percent_as_number":100.0}`
+}
+
+func TestCheckElasticsearch(t *testing.T) {
+	defaults := `{"persistent":{},"transient":{},"defaults":{"cluster.routing.allocation.disk.watermark.low":"85%","cluster.routing.allocation.disk.watermark.high":"90%","cluster.routing.allocation.disk.watermark.flood_stage":"95%"}}`
+	allocation := func(percent string) string {
+		return `[{"node":"es1","disk.percent":"` + percent + `","disk.avail":"1073741824"},{"node":"UNASSIGNED","disk.percent":null}]`
+	}
+	tests := []struct {
+		name       string
+		health     string
+		settings   string
+		allocation string
+		opts       Options
+		state      State
+		err        string
+	}{
+		{"green", esHealth("green", 3, 0, 0), defaults, allocation("10"), nil, StateOk, ""},
+		{"yellow", esHealth("yellow", 3, 2, 0), defaults, allocation("10"), nil, StateCritical, "status is yellow"},
+		{"yellow allowed", esHealth("yellow", 3, 2, 0), defaults, allocation("10"), Options{"yellow": "true"}, StateOk, ""},
+		{"red", esHealth("red", 3, 0, 0), defaults, allocation("10"), Options{"yellow": "true"}, StateCritical, "status is red"},
+		{"unassigned", esHealth("yellow", 3, 5, 0), defaults, allocation("10"), Options{"yellow": "true", "unassigned": "4"}, StateCritical, "5 unassigned, the maximum is 4"},
+		{"nodes", esHealth("green", 2, 0, 0), defaults, allocation("10"), Options{"nodes": "3"}, StateCritical, "2 nodes, the minimum is 3"},
+		{"pending", esHealth("green", 3, 0, 7), defaults, allocation("10"), Options{"pending": "5"}, StateCritical, "7 pending, the maximum is 5"},
+		{"disk ok", esHealth("green", 3, 0, 0), defaults, allocation("84"), Options{"disk": "true"}, StateOk, ""},
+		{"disk low", esHealth("green", 3, 0, 0), defaults, allocation("86"), Options{"disk": "true"}, StateOk, ""},
+		{"disk high", esHealth("green", 3, 0, 0), defaults, allocation("91"), Options{"disk": "true"}, StateWarning, "disk watermark exceeded"},
+		{"disk flood stage", esHealth("green", 3, 0, 0), defaults, allocation("96"), Options{"disk": "true"}, StateCritical, "disk watermark exceeded"},
+		{"transient setting", esHealth("green", 3, 0, 0),
+			`{"transient":{"cluster.routing.allocation.disk.watermark.high":"2gb"},"defaults":{}}`,
+			allocation("50"), Options{"disk": "true"}, StateWarning, "disk watermark exceeded"},
+		{"invalid setting", esHealth("green", 3, 0, 0),
+			`{"persistent":{"cluster.routing.allocation.disk.watermark.low":"much"}}`,
+			allocation("50"), Options{"disk": "true"}, StateCritical, "invalid watermark much"},
+		{"invalid json", "{", defaults, allocation("10"), nil, StateOk, "invalid response of /_cluster/health"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := fakeElasticsearch(t, tt.health, tt.settings, tt.allocation)
+			m := &Monitor{
+				Name:    "elasticsearch " + tt.name,
+				Url:     "elasticsearch://monitor:secret@" + host,
+				Timeout: 5 * time.Second,
+				Options: tt.opts,
+			}
+			res, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+			if res != nil && res.State != tt.state {
+				t.Fatalf("state %v, expected %v: %v", res.State, tt.state, res.Summary)
+			}
+		})
+	}
+}
+
+func TestCheckElasticsearchAuth(t *testing.T) {
+	host := fakeElasticsearch(t, esHealth("green", 1, 0, 0), "{}", "[]")
+	m := &Monitor{Name: "elasticsearch auth", Url: "opensearch://monitor:wrong@" + host, Timeout: 5 * time.Second}
+	_, err := check(m)
+	if err == nil || !strings.Contains(e.Trace(err), "401") {
+		t.Fatalf("expected the authorization to fail, got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/fcavani/e"
+)
+
+// CheckExec runs a Nagios (Monitoring Plugins) compatible command. The
+// command is the url host and path, exec:///usr/lib/nagios/plugins/check_load
+// or exec://check_load if it is in the PATH. Its arguments are in the
+// key args, split like the shell does. The exit code 0 is ok, 1
+// warning, 2 critical and 3, or any other, is unknown. The whole
+// process group is killed if the command doesn't finish a bit before
+// the monitor timeout, see budget, so its output is in the result.
+func CheckExec(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	name := url.Host + url.Path
+	if name == "" {
+		return nil, e.New("empty command")
+	}
+	args, err := splitArgs(opts.String("args", ""))
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+
+	limit := budget(m)
+	ctx := context.Background()
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// The pipes may be held by processes out of the group, don't wait
+	// for them past the monitor timeout.
+	cmd.WaitDelay = (m.Timeout - limit) / 2
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	res := parsePluginOutput(stdout.String())
+	if stderr.Len() > 0 {
+		res.Detail = strings.TrimSpace(res.Detail + "\n\nstderr:\n" + stderr.String())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		res.State = StateUnknown
+		return res, e.New("%v killed after %v", name, limit)
+	}
+	if exit, ok := err.(*exec.ExitError); ok {
+		switch exit.ExitCode() {
+		case 1:
+			res.State = StateWarning
+		case 2:
+			res.State = StateCritical
+		default:
+			res.State = StateUnknown
+		}
+		return res, e.New("%v: %v", res.State, res.Summary)
+	} else if err != nil {
+		res.State = StateUnknown
+		return res, e.Push(e.New(err), "can't run "+name)
+	}
+	res.State = StateOk
+	return res, nil
+}
+
+// parsePluginOutput parses the plugin output. The first line is the
+// summary, optionally followed by | and the performance data. The
+// other lines are the long output, after the first | in them is more
+// performance data.
+func parsePluginOutput(out string) *Result {
+	res := &Result{Detail: strings.TrimSpace(out)}
+	lines := strings.SplitN(out, "\n", 2)
+	first := strings.SplitN(lines[0], "|", 2)
+	res.Summary = strings.TrimSpace(first[0])
+	if len(first) == 2 {
+		res.Perf = append(res.Perf, ParsePerf(first[1])...)
+	}
+	if len(lines) == 2 {
+		if i := strings.Index(lines[1], "|"); i >= 0 {
+			res.Perf = append(res.Perf, ParsePerf(strings.Replace(lines[1][i+1:], "\n", " ", -1))...)
+		}
+	}
+	return res
+}
+
+// splitArgs splits s in words like the shell does with quotes and
+// backslashes, but without any expansion.
+func splitArgs(s string) ([]string, error) {
+	args := make([]string, 0)
+	var arg []rune
+	inArg := false
+	var quote rune
+	escape := false
+	for _, r := range s {
+		switch {
+		case escape:
+			arg = append(arg, r)
+			escape = false
+		case r == '\\' && quote != '\'':
+			escape = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg = append(arg, r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(arg))
+				arg = arg[:0]
+				inArg = false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escape {
+		return nil, e.New("unterminated quote or escape in %v", s)
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+func init() {
+	Add("exec", CheckExec)
+}
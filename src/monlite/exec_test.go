@@ -0,0 +1,50 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestCheckExec(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  string
+		state State
+		err   string
+	}{
+		{"ok", `-c 'echo "OK - fine|load=1;2;3"'`, StateOk, ""},
+		{"warning", `-c 'echo "WARNING - high"; exit 1'`, StateWarning, "WARNING - high"},
+		{"critical", `-c 'echo "CRITICAL - down"; exit 2'`, StateCritical, "CRITICAL - down"},
+		{"unknown", `-c 'exit 3'`, StateUnknown, ""},
+		{"killed", `-c 'echo "still running"; sleep 10'`, StateUnknown, "killed after 900ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: "exec " + tt.name, Url: "exec:///bin/sh", Timeout: time.Second, Options: Options{"args": tt.args}}
+			start := time.Now()
+			res, err := check(m)
+			if time.Since(start) >= m.Timeout {
+				t.Fatalf("took %v, more than the monitor timeout", time.Since(start))
+			}
+			if res == nil || res.State != tt.state {
+				t.Fatalf("result %v, expected the state %v", res, tt.state)
+			}
+			if tt.state == StateOk {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// CheckFile checks a file, like a backup or other generated artifact.
+// The url path is the file, if it is a glob pattern the newest file
+// matching it is checked (use the key path for patterns with ?). The
+// keys are:
+//
+//	age     maximum age of the file (duration or seconds).
+//	minsize minimum size, in bytes or with the suffixes K, M and G.
+//	maxsize maximum size.
+//	regex   regexp that must be in the last lines of the file.
+//	lines   how many lines regex looks at (10).
+//	cert    if true the file is a PEM certificate that can't expire
+//	        in less than days (30).
+func CheckFile(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	path := opts.String("path", url.Path)
+	if path == "" {
+		return nil, e.New("empty path")
+	}
+	name, fi, err := newestFile(path)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	age := time.Since(fi.ModTime())
+	res := &Result{
+		Summary: fmt.Sprintf("%v is %v old and has %v bytes", name, age.Truncate(time.Second), fi.Size()),
+		Perf: []Perf{
+			{Label: "age", Value: round(age.Seconds()), Unit: "s"},
+			{Label: "size", Value: float64(fi.Size()), Unit: "B"},
+		},
+	}
+	fail := func(format string, a ...interface{}) (*Result, error) {
+		res.State = StateCritical
+		res.Summary = fmt.Sprintf(format, a...) + ": " + res.Summary
+		return res, e.New("%v", res.Summary)
+	}
+
+	maxAge, err := opts.Duration("age", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if maxAge > 0 && age > maxAge {
+		return fail("older than %v", maxAge)
+	}
+	min, err := parseSize(opts.String("minsize", "0"))
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if fi.Size() < min {
+		return fail("smaller than %v bytes", min)
+	}
+	max, err := parseSize(opts.String("maxsize", "0"))
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if max > 0 && fi.Size() > max {
+		return fail("bigger than %v bytes", max)
+	}
+
+	if regex := opts.String("regex", ""); regex != "" {
+		re, err := regexp.Compile(regex)
+		if err != nil {
+			return nil, e.Push(e.New(err), "invalid regex")
+		}
+		n, err := opts.Int("lines", 10)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		lines, err := tail(name, n)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		if !re.Match(lines) {
+			res.Detail = "last lines:\n" + string(lines)
+			return fail("%v not found in the last %v lines", regex, n)
+		}
+	}
+
+	cert, err := opts.Bool("cert", false)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if cert {
+		days, err := opts.Int("days", 30)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		notAfter, subject, err := certExpiry(name)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		left := time.Until(notAfter)
+		res.Perf = append(res.Perf, Perf{Label: "expiry", Value: round(left.Hours() / 24), Unit: "d"})
+		res.Detail = fmt.Sprintf("certificate %v expires at %v", subject, notAfter.Format(time.RFC1123Z))
+		if left < time.Duration(days)*24*time.Hour {
+			return fail("certificate expires in %.1f days", left.Hours()/24)
+		}
+	}
+	return res, nil
+}
+
+// newestFile returns the newest file matching the glob pattern.
+func newestFile(pattern string) (string, os.FileInfo, error) {
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", nil, e.New(err)
+	}
+	if len(names) == 0 {
+		return "", nil, e.New("no file matching %v", pattern)
+	}
+	var newest string
+	var info os.FileInfo
+	for _, name := range names {
+		fi, err := os.Stat(name)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		if info == nil || fi.ModTime().After(info.ModTime()) {
+			newest, info = name, fi
+		}
+	}
+	if info == nil {
+		return "", nil, e.New("no regular file matching %v", pattern)
+	}
+	return newest, info, nil
+}
+
+// parseSize parses a size in bytes with the optional suffixes K, M
+// and G.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, e.Push(e.New(err), "invalid size")
+	}
+	return n * mult, nil
+}
+
+// tail returns the last n lines of the file.
+func tail(name string, n int) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	defer f.Close()
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	const block = 4096
+	buf := make([]byte, 0)
+	pos := end
+	for pos > 0 && bytes.Count(bytes.TrimRight(buf, "\n"), []byte{'\n'}) < n {
+		size := int64(block)
+		if pos < size {
+			size = pos
+		}
+		pos -= size
+		chunk := make([]byte, size)
+		_, err := f.ReadAt(chunk, pos)
+		if err != nil && err != io.EOF {
+			return nil, e.New(err)
+		}
+		buf = append(chunk, buf...)
+	}
+	lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte{'\n'})
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, []byte{'\n'}), nil
+}
+
+// certExpiry returns the earliest expiration of the certificates in
+// the PEM file.
+func certExpiry(name string) (time.Time, string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return time.Time{}, "", e.New(err)
+	}
+	var notAfter time.Time
+	var subject string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, "", e.Push(e.New(err), "invalid certificate")
+		}
+		if notAfter.IsZero() || cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+			subject = cert.Subject.String()
+		}
+	}
+	if notAfter.IsZero() {
+		return time.Time{}, "", e.New("no certificate in %v", name)
+	}
+	return notAfter, subject, nil
+}
+
+func init() {
+	Add("file", CheckFile)
+}
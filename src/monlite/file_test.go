@@ -0,0 +1,181 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// testCertificate creates a self signed certificate for 127.0.0.1 and
+// writes it in a PEM file, for the key ca.
+func testCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "monlite test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, ca
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		fail bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"10K", 10 << 10, false},
+		{"10kb", 10 << 10, false},
+		{" 3M ", 3 << 20, false},
+		{"2G", 2 << 30, false},
+		{"", 0, true},
+		{"lots", 0, true},
+		{"1.5M", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %v, expected an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %v %v, expected %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestTail(t *testing.T) {
+	// Longer than the blocks read from the end.
+	buf := bytes.NewBuffer(nil)
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(buf, "line %v\n", i)
+	}
+	name := filepath.Join(t.TempDir(), "log")
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, "line 5000"},
+		{3, "line 4998\nline 4999\nline 5000"},
+		{600, strings.Join(strings.Split(strings.TrimSpace(buf.String()), "\n")[4400:], "\n")},
+	}
+	for _, tt := range tests {
+		got, err := tail(name, tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("tail(%v) = %v lines, expected %v", tt.n, strings.Count(string(got), "\n")+1, strings.Count(tt.want, "\n")+1)
+		}
+	}
+	short := filepath.Join(t.TempDir(), "short")
+	if err := os.WriteFile(short, []byte("a\nb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := tail(short, 10); err != nil || string(got) != "a\nb" {
+		t.Fatalf("tail of a short file = %q %v", got, err)
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("backup-1.tar", 4096, 50*time.Hour)
+	newest := write("backup-2.tar", 2048, 2*time.Hour)
+	log := filepath.Join(dir, "backup.log")
+	if err := os.WriteFile(log, []byte("started\ncopying\nbackup finished ok\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, cert := testCertificate(t)
+	pattern := filepath.Join(dir, "backup-*.tar")
+
+	tests := []struct {
+		name string
+		url  string
+		opts Options
+		err  string
+	}{
+		{"newest of the glob", "file://" + pattern, Options{"age": "3h"}, ""},
+		{"old", "file://" + pattern, Options{"age": "1h"}, "older than 1h0m0s: " + newest},
+		{"age in seconds", "file://" + pattern, Options{"age": "3600"}, "older than 1h0m0s"},
+		{"size", "file://" + pattern, Options{"minsize": "1K", "maxsize": "4K"}, ""},
+		{"small", "file://" + pattern, Options{"minsize": "3K"}, "smaller than 3072 bytes"},
+		{"big", "file://" + pattern, Options{"maxsize": "1K"}, "bigger than 1024 bytes"},
+		{"path key", "file://", Options{"path": filepath.Join(dir, "backup-?.tar")}, ""},
+		{"no match", "file://" + filepath.Join(dir, "*.zip"), nil, "no file matching"},
+		{"regex", "file://" + log, Options{"regex": "finished ok$"}, ""},
+		{"regex not in the last lines", "file://" + log, Options{"regex": "^started", "lines": "2"}, "^started not found in the last 2 lines"},
+		{"cert", "file://" + cert, Options{"cert": "true", "days": "0"}, ""},
+		{"cert expires", "file://" + cert, Options{"cert": "true"}, "certificate expires in 0.0 days"},
+		{"not a cert", "file://" + log, Options{"cert": "true"}, "no certificate in " + log},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: "file " + tt.name, Url: tt.url, Options: tt.opts}
+			res, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFTP is an in-process ftp server with the files in memory. The
+// user is anonymous or user with the password secret.
+type fakeFTP struct {
+	ln       net.Listener
+	tls      *tls.Config
+	implicit bool
+	epsv     bool
+	mu       sync.Mutex
+	files    map[string][]byte
+	stored   []string
+}
+
+func newFakeFTP(t *testing.T, conf *tls.Config, implicit, epsv bool) *fakeFTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeFTP{ln: ln, tls: conf, implicit: implicit, epsv: epsv, files: map[string][]byte{"/pub/readme.txt": []byte("hi")}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeFTP) serve(conn net.Conn) {
+	defer conn.Close()
+	if f.implicit {
+		conn = tls.Server(conn, f.tls)
+	}
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ftp ready")
+	var data net.Listener
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+	prot, user := false, ""
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg := line, ""
+		if i := strings.Index(line, " "); i > 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+		switch cmd {
+		case "AUTH":
+			if f.tls == nil || f.implicit {
+				text.PrintfLine("502 no tls")
+				continue
+			}
+			text.PrintfLine("234 start tls")
+			conn = tls.Server(conn, f.tls)
+			text = textproto.NewConn(conn)
+		case "PBSZ", "TYPE":
+			text.PrintfLine("200 ok")
+		case "PROT":
+			prot = arg == "P"
+			text.PrintfLine("200 ok")
+		case "USER":
+			user = arg
+			text.PrintfLine("331 password")
+		case "PASS":
+			if user != "anonymous" && (user != "user" || arg != "secret") {
+				text.PrintfLine("530 login incorrect")
+				continue
+			}
+			text.PrintfLine("230 logged in")
+		case "EPSV", "PASV":
+			if cmd == "EPSV" && !f.epsv {
+				text.PrintfLine("502 not implemented")
+				continue
+			}
+			if data != nil {
+				data.Close()
+			}
+			data, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				text.PrintfLine("425 %v", err)
+				continue
+			}
+			port := data.Addr().(*net.TCPAddr).Port
+			if cmd == "EPSV" {
+				text.PrintfLine("229 Entering Extended Passive Mode (|||%v|)", port)
+			} else {
+				// The address is ignored by the client.
+				text.PrintfLine("227 Entering Passive Mode (10,0,0,1,%v,%v)", port>>8, port&0xff)
+			}
+		case "LIST", "STOR", "RETR":
+			if data == nil {
+				text.PrintfLine("425 no data connection")
+				continue
+			}
+			dconn, err := data.Accept()
+			data.Close()
+			data = nil
+			if err != nil {
+				text.PrintfLine("425 %v", err)
+				continue
+			}
+			text.PrintfLine("150 opening data connection")
+			if prot {
+				dconn = tls.Server(dconn, f.tls)
+			}
+			f.mu.Lock()
+			switch cmd {
+			case "LIST":
+				for name := range f.files {
+					if strings.HasPrefix(name, strings.TrimSuffix(arg, "/")+"/") {
+						fmt.Fprintf(dconn, "-rw-r--r-- 1 ftp ftp %v Jan 1 00:00 %v\r\n", len(f.files[name]), filepath.Base(name))
+					}
+				}
+			case "STOR":
+				f.mu.Unlock()
+				b, _ := ioutil.ReadAll(dconn)
+				f.mu.Lock()
+				f.files[arg] = b
+				f.stored = append(f.stored, arg)
+			case "RETR":
+				dconn.Write(f.files[arg])
+			}
+			f.mu.Unlock()
+			dconn.Close()
+			text.PrintfLine("226 transfer complete")
+		case "DELE":
+			f.mu.Lock()
+			delete(f.files, arg)
+			f.mu.Unlock()
+			text.PrintfLine("250 deleted")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestCheckFTP(t *testing.T) {
+	cert, ca := testCertificate(t)
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	tests := []struct {
+		name     string
+		scheme   string
+		user     string
+		tls      bool
+		implicit bool
+		epsv     bool
+		opts     Options
+		fail     bool
+	}{
+		{"anonymous list", "ftp", "", false, false, true, nil, false},
+		{"pasv transfer", "ftp", "user:secret@", false, false, false, Options{"transfer": "true"}, false},
+		{"wrong password", "ftp", "user:wrong@", false, false, true, nil, true},
+		{"explicit tls transfer", "ftps", "user:secret@", true, false, true, Options{"transfer": "true", "ca": ca}, false},
+		{"implicit tls transfer", "ftps", "user:secret@", true, true, true, Options{"transfer": "true", "ca": ca, "implicit": "true"}, false},
+		{"tls unknown authority", "ftps", "user:secret@", true, false, true, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c *tls.Config
+			if tt.tls {
+				c = conf
+			}
+			f := newFakeFTP(t, c, tt.implicit, tt.epsv)
+			url := tt.scheme + "://" + tt.user + f.ln.Addr().String() + "/pub"
+			m := &Monitor{Name: tt.name, Url: url, Timeout: 5 * time.Second, Options: tt.opts}
+			res, err := check(m)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("expected an error, result %v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(res.Detail, "readme.txt") {
+				t.Fatalf("listing without the file: %v", res.Detail)
+			}
+			if tt.opts["transfer"] == "true" {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				if len(f.stored) != 1 || !strings.HasPrefix(f.stored[0], "/pub/monlite-") {
+					t.Fatalf("stored %v", f.stored)
+				}
+				if _, ok := f.files[f.stored[0]]; ok {
+					t.Fatal("the file wasn't removed")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// Serving status of grpc.health.v1.HealthCheckResponse.
+var grpcServingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// CheckGrpc calls the standard gRPC health service,
+// grpc.health.v1.Health/Check, of the server in grpc://host:port or
+// grpcs://host:port for TLS. The key service is the service name to
+// check, empty for the whole server. The keys metadata.<name> are sent
+// as metadata and the TLS keys are the ones of tlsConfig. Only SERVING
+// is ok.
+func CheckGrpc(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	protocols := new(http.Protocols)
+	// The proxies are always tunnels, h2c can't be forwarded.
+	transport := &http.Transport{
+		DialContext:       d.DialContext,
+		DisableKeepAlives: true,
+		Protocols:         protocols,
+	}
+	scheme := "http"
+	if url.Scheme == "grpcs" {
+		conf, err := tlsConfig(opts, url.Host)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		transport.TLSClientConfig = conf
+		transport.ForceAttemptHTTP2 = true
+		protocols.SetHTTP2(true)
+		scheme = "https"
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	client := &http.Client{Transport: transport, Timeout: budget(m)}
+
+	service := opts.String("service", "")
+	// HealthCheckRequest{service: 1}, prefixed by the gRPC message header.
+	msg := make([]byte, 0, len(service)+2)
+	if service != "" {
+		msg = append(msg, 0x0a)
+		msg = binary.AppendUvarint(msg, uint64(len(service)))
+		msg = append(msg, service...)
+	}
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, err := http.NewRequest("POST", scheme+"://"+url.Host+"/grpc.health.v1.Health/Check", bytes.NewReader(body))
+	if err != nil {
+		return nil, e.New(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if m.Timeout > 0 {
+		req.Header.Set("Grpc-Timeout", fmt.Sprintf("%vm", int64(budget(m)/time.Millisecond)))
+	}
+	for k, v := range opts.Prefixed("metadata") {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, e.Push(e.New(err), "call failed")
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, e.New(err)
+	}
+	res := &Result{
+		Perf: []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}},
+	}
+	if resp.StatusCode != http.StatusOK {
+		return res, e.New("returned http status code %v", resp.StatusCode)
+	}
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		// Trailers-Only response.
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		message := resp.Trailer.Get("Grpc-Message")
+		if message == "" {
+			message = resp.Header.Get("Grpc-Message")
+		}
+		res.State = StateCritical
+		res.Summary = fmt.Sprintf("grpc status %v: %v", status, message)
+		return res, e.New("%v", res.Summary)
+	}
+	if len(data) < 5 || data[0] != 0 {
+		return res, e.New("invalid or compressed response")
+	}
+	n := binary.BigEndian.Uint32(data[1:5])
+	if int(n) > len(data)-5 {
+		return res, e.New("short response")
+	}
+	serving, err := healthStatus(data[5 : 5+n])
+	if err != nil {
+		return res, e.Forward(err)
+	}
+	name, ok := grpcServingStatus[serving]
+	if !ok {
+		name = fmt.Sprintf("status %v", serving)
+	}
+	res.Summary = name
+	if service != "" {
+		res.Summary = service + " " + name
+	}
+	if serving != 1 {
+		res.State = StateCritical
+		return res, e.New("%v", res.Summary)
+	}
+	return res, nil
+}
+
+// healthStatus decodes the field status of HealthCheckResponse. The
+// default value, UNKNOWN, isn't encoded.
+func healthStatus(msg []byte) (uint64, error) {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, e.New("invalid response")
+		}
+		msg = msg[n:]
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, e.New("invalid response")
+			}
+			msg = msg[n:]
+			if tag>>3 == 1 {
+				return v, nil
+			}
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0, e.New("invalid response")
+			}
+			msg = msg[n+int(l):]
+		case 1:
+			if len(msg) < 8 {
+				return 0, e.New("invalid response")
+			}
+			msg = msg[8:]
+		case 5:
+			if len(msg) < 4 {
+				return 0, e.New("invalid response")
+			}
+			msg = msg[4:]
+		default:
+			return 0, e.New("invalid response")
+		}
+	}
+	return 0, nil
+}
+
+func init() {
+	Add("grpc", CheckGrpc)
+	Add("grpcs", CheckGrpc)
+}
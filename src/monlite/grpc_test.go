@@ -0,0 +1,147 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    []byte
+		status uint64
+		fail   bool
+	}{
+		{"empty is unknown", nil, 0, false},
+		{"serving", []byte{0x08, 0x01}, 1, false},
+		{"not serving", []byte{0x08, 0x02}, 2, false},
+		{"unknown field before", []byte{0x12, 0x02, 'a', 'b', 0x08, 0x01}, 1, false},
+		{"fixed fields before", []byte{0x19, 1, 2, 3, 4, 5, 6, 7, 8, 0x25, 1, 2, 3, 4, 0x08, 0x03}, 3, false},
+		{"truncated varint", []byte{0x08}, 0, true},
+		{"truncated string", []byte{0x12, 0x05, 'a'}, 0, true},
+		{"truncated fixed64", []byte{0x19, 1, 2}, 0, true},
+		{"invalid wire type", []byte{0x0b}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := healthStatus(tt.msg)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Fatalf("status %v, expected %v", status, tt.status)
+			}
+		})
+	}
+}
+
+// grpcHealth is an in-process gRPC health server. The service is
+// serving unless its name is down, unknown services return the grpc
+// status NOT_FOUND and the metadata x-token must be secret.
+func grpcHealth(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != "/grpc.health.v1.Health/Check" || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("invalid request %v %v %v", r.Proto, r.URL.Path, r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+			t.Errorf("invalid message %x", body)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		service := ""
+		if msg := body[5:]; len(msg) > 2 && msg[0] == 0x0a {
+			service = string(msg[2 : 2+msg[1]])
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		if r.Header.Get("X-Token") != "secret" {
+			// Trailers-Only.
+			w.Header().Set("Grpc-Status", "16")
+			w.Header().Set("Grpc-Message", "bad token")
+			return
+		}
+		if service != "" && service != "api" && service != "down" {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "5")
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", "unknown service")
+			return
+		}
+		status := byte(1)
+		if service == "down" {
+			status = 2
+		}
+		w.Write([]byte{0, 0, 0, 0, 2, 0x08, status})
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+}
+
+func TestCheckGrpc(t *testing.T) {
+	h2c := httptest.NewUnstartedServer(grpcHealth(t))
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+
+	h2 := httptest.NewUnstartedServer(grpcHealth(t))
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: h2.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		opts    Options
+		summary string
+		fail    bool
+	}{
+		{"server", "grpc://" + h2c.Listener.Addr().String(), Options{"metadata.x-token": "secret"}, "SERVING", false},
+		{"service", "grpc://" + h2c.Listener.Addr().String(), Options{"metadata.x-token": "secret", "service": "api"}, "api SERVING", false},
+		{"not serving", "grpc://" + h2c.Listener.Addr().String(), Options{"metadata.x-token": "secret", "service": "down"}, "down NOT_SERVING", true},
+		{"unknown service", "grpc://" + h2c.Listener.Addr().String(), Options{"metadata.x-token": "secret", "service": "other"}, "grpc status 5: unknown service", true},
+		{"trailers only", "grpc://" + h2c.Listener.Addr().String(), nil, "grpc status 16: bad token", true},
+		{"tls", "grpcs://" + h2.Listener.Addr().String(), Options{"metadata.x-token": "secret", "ca": ca, "servername": "example.com"}, "SERVING", false},
+		{"tls unknown authority", "grpcs://" + h2.Listener.Addr().String(), Options{"metadata.x-token": "secret", "servername": "example.com"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: tt.url, Timeout: 5 * time.Second, Options: tt.opts}
+			res, err := check(m)
+			if tt.fail && err == nil {
+				t.Fatalf("expected an error, result %v", res)
+			}
+			if !tt.fail && err != nil {
+				t.Fatal(err)
+			}
+			if tt.summary == "" {
+				return
+			}
+			if res == nil || !strings.HasPrefix(res.Summary, tt.summary) {
+				t.Fatalf("result %v, expected the summary %q", res, tt.summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/fcavani/log"
+)
+
+// MaxHeartbeatBody is the maximum size of the body of a ping kept to
+// be sent in the alert.
+var MaxHeartbeatBody int64 = 10 * 1024
+
+// heartbeatTimer is the part of time.Timer used by the heartbeats.
+type heartbeatTimer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// The clock of the heartbeats, the tests replace them.
+var (
+	heartbeatNow       = time.Now
+	heartbeatAfterFunc = func(d time.Duration, f func()) heartbeatTimer {
+		return time.AfterFunc(d, f)
+	}
+)
+
+type beat struct {
+	since    time.Time
+	start    time.Time
+	last     time.Time
+	failed   time.Time
+	duration time.Duration
+	body     string
+	// limit is the periode of the monitor plus the grace, timer wakes
+	// the monitor when it passes without a ping.
+	limit time.Duration
+	timer heartbeatTimer
+	wake  chan struct{}
+}
+
+// alert makes the monitor check now, if it isn't already going to.
+func (b *beat) alert() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// HeartbeatServer receives the pings of the passive monitors, the ones
+// with the heartbeat scheme. The jobs ping /hb/<token> when they
+// finish, /hb/<token>/start when they begin and /hb/<token>/fail if
+// they fail. The body of the request is sent in the alert.
+type HeartbeatServer struct {
+	mutex sync.Mutex
+	beats map[string]*beat
+}
+
+// Heartbeats is the server used by the heartbeat checker.
+var Heartbeats = &HeartbeatServer{beats: make(map[string]*beat)}
+
+// Watch makes the server accept the pings for the monitor m. It must be
+// called before m.Start, the monitor is checked as soon as the job fails
+// or the periode plus the grace passes without a ping.
+func (h *HeartbeatServer) Watch(m *Monitor) error {
+	u, err := url.Parse(m.Url)
+	if err != nil {
+		return e.New(err)
+	}
+	if u.Scheme != "heartbeat" {
+		return e.New("%v isn't a heartbeat monitor", m.Name)
+	}
+	if u.Host == "" {
+		return e.New("empty token")
+	}
+	grace, err := options(m, u).Duration("grace", time.Minute)
+	if err != nil {
+		return e.Forward(err)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.beats[u.Host]; ok {
+		return e.New("token of %v is in use", m.Name)
+	}
+	b := &beat{
+		since: heartbeatNow(),
+		limit: m.Periode + grace,
+		wake:  make(chan struct{}, 1),
+	}
+	b.timer = heartbeatAfterFunc(b.limit, b.alert)
+	m.wake = b.wake
+	h.beats[u.Host] = b
+	return nil
+}
+
+// Unwatch makes the server forget the monitor m, its pings are refused.
+func (h *HeartbeatServer) Unwatch(m *Monitor) error {
+	u, err := url.Parse(m.Url)
+	if err != nil {
+		return e.New(err)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	b, ok := h.beats[u.Host]
+	if !ok || u.Scheme != "heartbeat" {
+		return e.New("%v isn't watched", m.Name)
+	}
+	b.timer.Stop()
+	delete(h.beats, u.Host)
+	return nil
+}
+
+func (h *HeartbeatServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) < 2 || len(path) > 3 || path[0] != "hb" {
+		http.NotFound(w, r)
+		return
+	}
+	event := ""
+	if len(path) == 3 {
+		event = path[2]
+		if event != "start" && event != "fail" {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxHeartbeatBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	b, ok := h.beats[path[1]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	now := heartbeatNow()
+	b.timer.Reset(b.limit)
+	switch event {
+	case "start":
+		b.start = now
+	case "fail":
+		b.failed = now
+		b.body = string(body)
+		if !b.start.IsZero() {
+			b.duration = now.Sub(b.start)
+			b.start = time.Time{}
+		}
+		b.alert()
+	default:
+		b.last = now
+		b.failed = time.Time{}
+		b.body = string(body)
+		if !b.start.IsZero() {
+			b.duration = now.Sub(b.start)
+			b.start = time.Time{}
+		}
+	}
+	log.DebugLevel().Printf("Heartbeat %v %v from %v", path[1], event, r.RemoteAddr)
+	fmt.Fprintln(w, "OK")
+}
+
+// CheckHeartbeat fails if the job of heartbeat://<token> didn't ping in
+// the monitor periode plus the key grace (60s), if its last ping was a
+// fail or if it started and didn't finish in this time. The monitor
+// isn't polled only each periode, HeartbeatServer wakes it when one of
+// these happens.
+func CheckHeartbeat(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	grace, err := opts.Duration("grace", time.Minute)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	Heartbeats.mutex.Lock()
+	b, ok := Heartbeats.beats[url.Host]
+	var cp beat
+	if ok {
+		cp = *b
+	}
+	Heartbeats.mutex.Unlock()
+	if !ok {
+		return nil, e.New("heartbeat %v isn't watched", m.Name)
+	}
+
+	limit := m.Periode + grace
+	now := heartbeatNow()
+	res := &Result{Detail: cp.body}
+	if cp.last.IsZero() {
+		res.Summary = fmt.Sprintf("never pinged, watching since %v", cp.since.Format(time.RFC1123Z))
+	} else {
+		res.Summary = fmt.Sprintf("last ping %v ago", now.Sub(cp.last).Truncate(time.Second))
+		res.Perf = append(res.Perf, Perf{Label: "age", Value: round(now.Sub(cp.last).Seconds()), Unit: "s"})
+	}
+	if cp.duration > 0 {
+		res.Summary += fmt.Sprintf(", last run took %v", cp.duration)
+		res.Perf = append(res.Perf, Perf{Label: "duration", Value: round(cp.duration.Seconds()), Unit: "s"})
+	}
+
+	switch {
+	case !cp.failed.IsZero():
+		res.State = StateCritical
+		return res, e.New("job failed at %v", cp.failed.Format(time.RFC1123Z))
+	case !cp.start.IsZero() && now.Sub(cp.start) >= limit:
+		res.State = StateCritical
+		return res, e.New("job running for %v", now.Sub(cp.start).Truncate(time.Second))
+	case cp.last.IsZero() && now.Sub(cp.since) >= limit:
+		res.State = StateCritical
+		return res, e.New("no ping in %v", now.Sub(cp.since).Truncate(time.Second))
+	case !cp.last.IsZero() && now.Sub(cp.last) >= limit:
+		res.State = StateCritical
+		return res, e.New("no ping since %v", cp.last.Format(time.RFC1123Z))
+	}
+	return res, nil
+}
+
+func init() {
+	Add("heartbeat", CheckHeartbeat)
+}
@@ -0,0 +1,219 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is the clock of the heartbeats in the tests, the time only
+// moves with advance and the timers only fire with fire.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	d     time.Duration
+	f     func()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	t.d = d
+	return true
+}
+
+func (t *fakeTimer) Stop() bool {
+	return true
+}
+
+// useFakeClock replaces the clock of the heartbeats until the end of
+// the test.
+func useFakeClock(t *testing.T) *fakeClock {
+	c := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	oldNow, oldAfterFunc := heartbeatNow, heartbeatAfterFunc
+	heartbeatNow = func() time.Time {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.now
+	}
+	heartbeatAfterFunc = func(d time.Duration, f func()) heartbeatTimer {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		timer := &fakeTimer{clock: c, d: d, f: f}
+		c.timers = append(c.timers, timer)
+		return timer
+	}
+	t.Cleanup(func() { heartbeatNow, heartbeatAfterFunc = oldNow, oldAfterFunc })
+	return c
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// timer returns the duration of the last timer armed and its function.
+func (c *fakeClock) timer() (time.Duration, func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	last := c.timers[len(c.timers)-1]
+	return last.d, last.f
+}
+
+// watchHeartbeat starts the monitor of token and returns its failures.
+func watchHeartbeat(t *testing.T, token string, periode time.Duration, grace string) (*Monitor, chan error) {
+	failed := make(chan error, 10)
+	m := &Monitor{
+		Name:    "heartbeat " + token,
+		Url:     "heartbeat://" + token,
+		Timeout: time.Second,
+		Periode: periode,
+		Options: Options{"grace": grace},
+		OnFail: func(m *Monitor) error {
+			failed <- m.Err()
+			return nil
+		},
+	}
+	err := Heartbeats.Watch(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Stop()
+		if err := Heartbeats.Unwatch(m); err != nil {
+			t.Error(err)
+		}
+	})
+	return m, failed
+}
+
+func heartbeatPing(t *testing.T, s *httptest.Server, path, body string) int {
+	resp, err := http.Post(s.URL+path, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHeartbeatMissed(t *testing.T) {
+	const limit = time.Hour + time.Minute
+	clock := useFakeClock(t)
+	m, failed := watchHeartbeat(t, "missed", time.Hour, "1m")
+	s := httptest.NewServer(Heartbeats)
+	defer s.Close()
+	if d, _ := clock.timer(); d != limit {
+		t.Fatalf("timer of %v, expected the periode plus the grace %v", d, limit)
+	}
+	clock.advance(30 * time.Minute)
+	heartbeatPing(t, s, "/hb/missed", "")
+	// The ping moved the deadline.
+	clock.advance(time.Hour)
+	if _, err := check(m); err != nil {
+		t.Fatalf("failed before the limit: %v", err)
+	}
+	clock.advance(time.Minute)
+	d, fire := clock.timer()
+	if d != limit {
+		t.Fatalf("timer reset to %v, expected %v", d, limit)
+	}
+	fire()
+	select {
+	case err := <-failed:
+		if err == nil || !strings.Contains(err.Error(), "no ping since") {
+			t.Fatalf("error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the timer didn't wake the monitor")
+	}
+}
+
+func TestCheckHeartbeat(t *testing.T) {
+	clock := useFakeClock(t)
+	m := &Monitor{Name: "heartbeat check", Url: "heartbeat://check", Periode: time.Hour, Options: Options{"grace": "10m"}}
+	if err := Heartbeats.Watch(m); err != nil {
+		t.Fatal(err)
+	}
+	defer Heartbeats.Unwatch(m)
+	s := httptest.NewServer(Heartbeats)
+	defer s.Close()
+
+	clock.advance(70 * time.Minute)
+	if _, err := check(m); err == nil || !strings.Contains(err.Error(), "no ping in 1h10m0s") {
+		t.Fatalf("never pinged: %v", err)
+	}
+	heartbeatPing(t, s, "/hb/check/start", "")
+	clock.advance(5 * time.Minute)
+	heartbeatPing(t, s, "/hb/check", "done")
+	res, err := check(m)
+	if err != nil || !strings.Contains(res.Summary, "last run took 5m0s") || res.Detail != "done" {
+		t.Fatalf("after the run: %v %v", res, err)
+	}
+	heartbeatPing(t, s, "/hb/check/start", "")
+	clock.advance(80 * time.Minute)
+	if _, err := check(m); err == nil || !strings.Contains(err.Error(), "job running for 1h20m0s") {
+		t.Fatalf("long run: %v", err)
+	}
+}
+
+func TestHeartbeatFail(t *testing.T) {
+	useFakeClock(t)
+	_, failed := watchHeartbeat(t, "fail", time.Hour, "1h")
+	s := httptest.NewServer(Heartbeats)
+	defer s.Close()
+	heartbeatPing(t, s, "/hb/fail/fail", "disk full")
+	select {
+	case err := <-failed:
+		if err == nil || !strings.Contains(err.Error(), "job failed") {
+			t.Fatalf("error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the fail wasn't reported before the periode")
+	}
+}
+
+func TestHeartbeatUnwatch(t *testing.T) {
+	useFakeClock(t)
+	m := &Monitor{Name: "heartbeat unwatch", Url: "heartbeat://unwatch", Periode: time.Hour}
+	if err := Heartbeats.Watch(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := Heartbeats.Watch(m); err == nil {
+		t.Fatal("the token was watched twice")
+	}
+	s := httptest.NewServer(Heartbeats)
+	defer s.Close()
+	if code := heartbeatPing(t, s, "/hb/unwatch", ""); code != http.StatusOK {
+		t.Fatalf("ping returned %v", code)
+	}
+	if err := Heartbeats.Unwatch(m); err != nil {
+		t.Fatal(err)
+	}
+	if code := heartbeatPing(t, s, "/hb/unwatch", ""); code != http.StatusNotFound {
+		t.Fatalf("ping of an unwatched token returned %v", code)
+	}
+	if err := Heartbeats.Unwatch(m); err == nil {
+		t.Fatal("unwatched twice")
+	}
+	if err := Heartbeats.Watch(m); err != nil {
+		t.Fatalf("watch after unwatch: %v", err)
+	}
+	Heartbeats.Unwatch(m)
+}
@@ -0,0 +1,157 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// MaxHTTPBody is the biggest body read for the assertions.
+var MaxHTTPBody int64 = 1 << 20
+
+// httpRequest creates the request to rawurl from the keys:
+//
+//	method        the http method (GET).
+//	body          the request body.
+//	header.<name> the headers, like header.Host.
+func httpRequest(opts Options, rawurl string) (*http.Request, error) {
+	var body io.Reader
+	if b := opts.String("body", ""); b != "" {
+		body = strings.NewReader(b)
+	}
+	req, err := http.NewRequest(strings.ToUpper(opts.String("method", "GET")), rawurl, body)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	for k, v := range opts.Prefixed("header") {
+		if strings.EqualFold(k, "host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// httpBody reads the body of the response, up to MaxHTTPBody.
+func httpBody(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxHTTPBody))
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't read the body")
+	}
+	return body, nil
+}
+
+// httpAssert checks the response. The status code must be 2xx, or one
+// of the comma separated list in the key status, and the body must
+// match the regexp in the key expect.
+func httpAssert(opts Options, resp *http.Response, body []byte) error {
+	if codes := opts.List("status"); len(codes) > 0 {
+		found := false
+		for _, c := range codes {
+			n, err := strconv.Atoi(c)
+			if err != nil {
+				return e.New("invalid status %v", c)
+			}
+			if n == resp.StatusCode {
+				found = true
+			}
+		}
+		if !found {
+			return e.New("returned status code %v, expected %v", resp.StatusCode, strings.Join(codes, ", "))
+		}
+	} else if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return e.New("returned status code %v, expected 2xx", resp.StatusCode)
+	}
+	if expect := opts.String("expect", ""); expect != "" {
+		re, err := regexp.Compile(expect)
+		if err != nil {
+			return e.Push(e.New(err), "invalid expect")
+		}
+		if !re.Match(body) {
+			return e.New("body doesn't match %v", expect)
+		}
+	}
+	return nil
+}
+
+// CheckHTTP does a request to the http:// or https:// url through the
+// dialer of the monitor. The request is made with the keys of
+// httpRequest and checked with the ones of httpAssert, https uses the
+// TLS keys of tlsConfig. If the key change is true the monitor fails
+// when the body is different from the one of the previous check, see
+// contentChange, and the alert has the diff. The url query is sent as
+// is, the keys are only the ones of the service section.
+func CheckHTTP(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	// Without servername each redirect is verified with its own host.
+	conf, err := tlsConfig(opts, "")
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	client := &http.Client{Transport: d.Transport(conf), Timeout: budget(m)}
+	req, err := httpRequest(opts, url.String())
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, e.Push(e.New(err), "request failed")
+	}
+	defer resp.Body.Close()
+	body, err := httpBody(resp)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	res := &Result{
+		Summary: fmt.Sprintf("%v %v returned %v", req.Method, req.URL.RequestURI(), resp.Status),
+		Perf:    []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}},
+	}
+	err = httpAssert(opts, resp, body)
+	if err != nil {
+		res.State = StateCritical
+		res.Detail = "body:\n" + snippet(body)
+		return res, e.Forward(err)
+	}
+	change, err := opts.Bool("change", false)
+	if err != nil {
+		return res, e.Forward(err)
+	}
+	if change {
+		hash, diff, accept, err := contentChange(m, opts, body)
+		if err != nil {
+			return res, e.Forward(err)
+		}
+		res.Summary += ", content " + hash[:12]
+		res.accept = accept
+		if diff != "" {
+			res.changed = true
+			res.State = StateWarning
+			res.Detail = diff
+			return res, e.New("content of %v changed", url.Redacted())
+		}
+	}
+	return res, nil
+}
+
+func init() {
+	Add("http", CheckHTTP)
+	Add("https", CheckHTTP)
+}
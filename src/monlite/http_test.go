@@ -0,0 +1,104 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// localhostCertificate creates a self signed certificate only for the
+// name localhost and appends it to the ca file.
+func localhostCertificate(t *testing.T, ca string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(ca, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCheckHTTPRedirect(t *testing.T) {
+	ipCert, ca := testCertificate(t)
+	nameCert := localhostCertificate(t, ca)
+
+	www := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("welcome"))
+	}))
+	www.TLS = &tls.Config{Certificates: []tls.Certificate{nameCert}}
+	www.StartTLS()
+	defer www.Close()
+	_, port, _ := net.SplitHostPort(www.Listener.Addr().String())
+	target := "https://localhost:" + port + "/"
+
+	apex := httptest.NewUnstartedServer(http.RedirectHandler(target, http.StatusMovedPermanently))
+	apex.TLS = &tls.Config{Certificates: []tls.Certificate{ipCert}}
+	apex.StartTLS()
+	defer apex.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		opts Options
+		err  string
+	}{
+		{"redirect to another host", apex.URL + "/", Options{"ca": ca, "expect": "welcome"}, ""},
+		{"target", target, Options{"ca": ca, "expect": "welcome"}, ""},
+		{"servername pinned", apex.URL + "/", Options{"ca": ca, "servername": "localhost"}, "request failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: tt.url, Timeout: 5 * time.Second, Options: tt.opts}
+			res, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+		})
+	}
+}
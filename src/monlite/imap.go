@@ -0,0 +1,92 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/mxk/go-imap/imap"
+)
+
+// CheckImap connects to imap://[user:password@]host[:port] or imaps://
+// through the dialer of the monitor. imaps, or the port 993, uses TLS
+// from the start and imap uses STARTTLS if the server has it, with the
+// TLS keys of tlsConfig. It sends an ID, if the server has it, a NOOP
+// and, if the url has an user, logs in.
+func CheckImap(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	conf, err := tlsConfig(opts, url.Hostname())
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	port := url.Port()
+	if port == "" {
+		port = "143"
+		if url.Scheme == "imaps" {
+			port = "993"
+		}
+	}
+	addr := net.JoinHostPort(url.Hostname(), port)
+
+	start := time.Now()
+	var conn net.Conn
+	if url.Scheme == "imaps" || port == "993" {
+		conn, err = d.DialTLS("tcp", addr, conf)
+	} else {
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	c, err := imap.NewClient(conn, url.Hostname(), budget(m))
+	if err != nil {
+		conn.Close()
+		return nil, e.Push(e.New(err), "imap connection failed")
+	}
+	defer c.Logout(budget(m))
+
+	res := &Result{Summary: "imap server " + url.Hostname()}
+	if c.Caps["STARTTLS"] {
+		_, err := c.StartTLS(conf)
+		if err != nil {
+			return res, e.Push(e.New(err), "starttls failed")
+		}
+		res.Summary += " with STARTTLS"
+	}
+	if c.Caps["ID"] {
+		_, err := c.ID("name", "goimap")
+		if err != nil {
+			return res, e.Push(e.New(err), "id failed")
+		}
+	}
+	_, err = imap.Wait(c.Noop())
+	if err != nil {
+		return res, e.Push(e.New(err), "noop failed")
+	}
+	if url.User != nil {
+		pass, ok := url.User.Password()
+		if url.User.Username() != "" && ok {
+			_, err = imap.Wait(c.Login(url.User.Username(), pass))
+			if err != nil {
+				res.State = StateCritical
+				return res, e.Push(e.New(err), "login failed")
+			}
+		}
+	}
+	res.Perf = []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}}
+	return res, nil
+}
+
+func init() {
+	Add("imap", CheckImap)
+	Add("imaps", CheckImap)
+}
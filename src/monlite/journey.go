@@ -0,0 +1,239 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+var journeyVar = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// expand replaces ${name} in s by the variable name.
+func expand(s string, vars map[string]string) string {
+	return journeyVar.ReplaceAllStringFunc(s, func(v string) string {
+		if val, ok := vars[v[2:len(v)-1]]; ok {
+			return val
+		}
+		return v
+	})
+}
+
+// journeySteps returns the numbers of the steps, the keys step.<n>.*,
+// in order.
+func journeySteps(opts Options) ([]int, error) {
+	found := make(map[int]bool)
+	for k := range opts.Prefixed("step") {
+		s := strings.SplitN(k, ".", 2)[0]
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, e.New("invalid step %v", s)
+		}
+		found[n] = true
+	}
+	steps := make([]int, 0, len(found))
+	for n := range found {
+		steps = append(steps, n)
+	}
+	sort.Ints(steps)
+	return steps, nil
+}
+
+// extract gets a value of the response with the rule:
+//
+//	regex:<regexp> the first group, or the whole match.
+//	json:<path>    the value in the path of the json body, the keys
+//	               and array indexes separated by dots, like data.0.id.
+//	header:<name>  the header value.
+func extract(rule string, resp *http.Response, body []byte) (string, error) {
+	s := strings.SplitN(rule, ":", 2)
+	if len(s) != 2 {
+		return "", e.New("invalid extract rule %v", rule)
+	}
+	switch s[0] {
+	case "regex":
+		re, err := regexp.Compile(s[1])
+		if err != nil {
+			return "", e.Push(e.New(err), "invalid regexp")
+		}
+		m := re.FindSubmatch(body)
+		if m == nil {
+			return "", e.New("%v not found", s[1])
+		}
+		if len(m) > 1 {
+			return string(m[1]), nil
+		}
+		return string(m[0]), nil
+	case "json":
+		var v interface{}
+		err := json.Unmarshal(body, &v)
+		if err != nil {
+			return "", e.Push(e.New(err), "invalid json body")
+		}
+		for _, key := range strings.Split(s[1], ".") {
+			switch val := v.(type) {
+			case map[string]interface{}:
+				var ok bool
+				v, ok = val[key]
+				if !ok {
+					return "", e.New("%v not found", s[1])
+				}
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(val) {
+					return "", e.New("%v not found", s[1])
+				}
+				v = val[i]
+			default:
+				return "", e.New("%v not found", s[1])
+			}
+		}
+		if str, ok := v.(string); ok {
+			return str, nil
+		}
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return "", e.New(err)
+		}
+		return string(buf), nil
+	case "header":
+		val := resp.Header.Get(s[1])
+		if val == "" {
+			return "", e.New("header %v not found", s[1])
+		}
+		return val, nil
+	}
+	return "", e.New("invalid extract rule %v", rule)
+}
+
+// CheckJourney runs the http requests of the steps in order, sharing the
+// cookies. journey://name only names the journey, the steps are the keys
+// step.<n>.<key>, ordered by n:
+//
+//	url            the url of the request.
+//	name           the name of the step in the alerts.
+//	redirect       if false the redirects aren't followed (true).
+//	extract.<var>  stores a value of the response, see extract, in the
+//	               variable var. ${var} is replaced by its value in the
+//	               keys of the next steps.
+//
+// and the keys of httpRequest and httpAssert. The TLS keys of tlsConfig
+// are shared by all steps. The monitor timeout is the timeout of the
+// whole journey, the steps share it.
+func CheckJourney(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	steps, err := journeySteps(opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if len(steps) == 0 {
+		return nil, e.New("journey without steps")
+	}
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	conf, err := tlsConfig(opts, "")
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	follow := true
+	client := &http.Client{
+		Transport: d.Transport(conf),
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !follow {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= 10 {
+				return e.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), budget(m))
+	defer cancel()
+	vars := make(map[string]string)
+	res := &Result{}
+	lines := make([]string, 0, len(steps))
+	start := time.Now()
+	for _, n := range steps {
+		step := make(Options)
+		for k, v := range opts.Prefixed("step." + strconv.Itoa(n)) {
+			step[k] = expand(v, vars)
+		}
+		name := step.String("name", strconv.Itoa(n))
+		fail := func(err error, body []byte) (*Result, error) {
+			res.State = StateCritical
+			res.Summary = fmt.Sprintf("step %v failed", name)
+			if body != nil {
+				lines = append(lines, "", "response:", snippet(body))
+			}
+			res.Detail = strings.Join(lines, "\n")
+			return res, e.Push(err, res.Summary)
+		}
+		follow, err = step.Bool("redirect", true)
+		if err != nil {
+			return fail(err, nil)
+		}
+		rawurl := step.String("url", "")
+		if rawurl == "" {
+			return fail(e.New("step without url"), nil)
+		}
+		req, err := httpRequest(step, rawurl)
+		if err != nil {
+			return fail(err, nil)
+		}
+		t := time.Now()
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%v: %v %v", name, req.Method, rawurl))
+			return fail(e.Push(e.New(err), "request failed"), nil)
+		}
+		body, err := httpBody(resp)
+		resp.Body.Close()
+		latency := time.Since(t)
+		lines = append(lines, fmt.Sprintf("%v: %v %v returned %v in %v", name, req.Method, rawurl, resp.Status, latency))
+		res.Perf = append(res.Perf, Perf{Label: name, Value: round(latency.Seconds() * 1000), Unit: "ms"})
+		if err != nil {
+			return fail(err, nil)
+		}
+		err = httpAssert(step, resp, body)
+		if err != nil {
+			return fail(err, body)
+		}
+		for v, rule := range step.Prefixed("extract") {
+			val, err := extract(rule, resp, body)
+			if err != nil {
+				return fail(e.Push(err, "can't extract "+v), body)
+			}
+			vars[v] = val
+		}
+	}
+	res.Perf = append(res.Perf, Perf{Label: "total", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"})
+	res.Summary = fmt.Sprintf("%v steps in %v", len(steps), time.Since(start))
+	res.Detail = strings.Join(lines, "\n")
+	return res, nil
+}
+
+func init() {
+	Add("journey", CheckJourney)
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestCheckJourney(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+			w.Write([]byte(`{"data":{"token":"t1"}}`))
+		case "/orders":
+			c, err := r.Cookie("session")
+			if err != nil || c.Value != "s1" || r.Header.Get("Authorization") != "Bearer t1" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"orders":[]}`))
+		case "/slow":
+			time.Sleep(600 * time.Millisecond)
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer s.Close()
+	tests := []struct {
+		name string
+		opts Options
+		err  string
+	}{
+		{"extract", Options{
+			"step.1.url":                  s.URL + "/login",
+			"step.1.extract.token":        "json:data.token",
+			"step.2.url":                  s.URL + "/orders",
+			"step.2.header.Authorization": "Bearer ${token}",
+			"step.2.expect":               `"orders":`,
+		}, ""},
+		{"wrong token", Options{
+			"step.1.url":                  s.URL + "/login",
+			"step.2.name":                 "orders",
+			"step.2.url":                  s.URL + "/orders",
+			"step.2.header.Authorization": "Bearer other",
+		}, "step orders failed"},
+		// Each step is shorter than the timeout, the journey isn't.
+		{"journey timeout", Options{
+			"step.1.url":  s.URL + "/slow",
+			"step.2.url":  s.URL + "/slow",
+			"step.2.name": "second",
+		}, "step second failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: "journey://shop", Timeout: time.Second, Options: tt.opts}
+			start := time.Now()
+			res, err := check(m)
+			if time.Since(start) >= m.Timeout {
+				t.Fatalf("took %v, more than the monitor timeout", time.Since(start))
+			}
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeLdap answers the bind with success if the password is secret and
+// the search with one entry with attrs.
+func fakeLdap(t *testing.T, dn string, attrs []string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fakeLdapConn(conn, dn, attrs)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func ldapResponse(id int64, tag ber.Tag, code int) *ber.Packet {
+	p := ber.NewSequence("response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "id"))
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "op")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnostic"))
+	p.AppendChild(op)
+	return p
+}
+
+func fakeLdapConn(conn net.Conn, dn string, attrs []string) {
+	defer conn.Close()
+	for {
+		req, err := ber.ReadPacket(conn)
+		if err != nil || len(req.Children) < 2 {
+			return
+		}
+		id := req.Children[0].Value.(int64)
+		switch req.Children[1].Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultSuccess
+			if string(req.Children[1].Children[2].Data.Bytes()) != "secret" {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			conn.Write(ldapResponse(id, ldap.ApplicationBindResponse, int(code)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			p := ber.NewSequence("response")
+			p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "id"))
+			entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "entry")
+			entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "dn"))
+			list := ber.NewSequence("attributes")
+			for _, a := range attrs {
+				attr := ber.NewSequence("attribute")
+				attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a, "name"))
+				vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+				vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "1", "value"))
+				attr.AppendChild(vals)
+				list.AppendChild(attr)
+			}
+			entry.AppendChild(list)
+			p.AppendChild(entry)
+			conn.Write(p.Bytes())
+			conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, int(ldap.LDAPResultSuccess)).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func TestCheckLdap(t *testing.T) {
+	dn := "cn=admin,dc=example,dc=com"
+	all := []string{"cn", "uid", "uidNumber", "gidNumber"}
+	tests := []struct {
+		name  string
+		pass  string
+		attrs []string
+		fail  bool
+	}{
+		{"ok", "secret", all, false},
+		{"wrong password", "wrong", all, true},
+		{"missing attribute", "secret", all[:3], true},
+		{"extra attribute", "secret", append(all, "mail"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := fakeLdap(t, dn, tt.attrs)
+			m := &Monitor{Name: tt.name, Url: "ldap://admin:" + tt.pass + "@" + addr + "/dc=example,dc=com", Timeout: 5 * time.Second}
+			res, err := check(m)
+			if tt.fail && err == nil {
+				t.Fatalf("expected an error, result %v", res)
+			}
+			if !tt.fail && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	utilUrl "github.com/fcavani/net/url"
+)
+
+// promSample is a line of the Prometheus text exposition format.
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// promQuoted reads the quoted string at the beginning of s and returns
+// it unescaped and the rest of s.
+func promQuoted(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", e.New("expected a quoted string in %v", s)
+	}
+	var buf strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return buf.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			default:
+				buf.WriteByte(s[i])
+			}
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return "", "", e.New("unterminated string in %v", s)
+}
+
+// promLabels reads the label set {name<op>"value",...} at the beginning
+// of s, ops are the accepted operators in order of precedence. It calls
+// f for each label and returns the rest of s.
+func promLabels(s string, ops []string, f func(name, op, value string) error) (string, error) {
+	s = strings.TrimPrefix(s, "{")
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		i := strings.IndexAny(s, "=!~")
+		if i <= 0 {
+			return "", e.New("invalid labels %v", s)
+		}
+		name := strings.TrimSpace(s[:i])
+		s = s[i:]
+		op := ""
+		for _, o := range ops {
+			if strings.HasPrefix(s, o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return "", e.New("invalid label operator in %v", s)
+		}
+		value, rest, err := promQuoted(strings.TrimSpace(s[len(op):]))
+		if err != nil {
+			return "", e.Forward(err)
+		}
+		err = f(name, op, value)
+		if err != nil {
+			return "", e.Forward(err)
+		}
+		s = rest
+	}
+}
+
+// parseMetrics parses the samples of the Prometheus text exposition
+// format. The comments and the timestamps are ignored.
+func parseMetrics(body []byte) ([]promSample, error) {
+	samples := make([]promSample, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, int(MaxHTTPBody))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, "{ \t")
+		if i <= 0 {
+			return nil, e.New("invalid line %v", n)
+		}
+		p := promSample{name: line[:i], labels: make(map[string]string)}
+		rest := line[i:]
+		if rest[0] == '{' {
+			var err error
+			rest, err = promLabels(rest, []string{"="}, func(name, op, value string) error {
+				p.labels[name] = value
+				return nil
+			})
+			if err != nil {
+				return nil, e.Push(err, fmt.Sprintf("invalid line %v", n))
+			}
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, e.New("line %v without value", n)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, e.New("invalid value %v in line %v", fields[0], n)
+		}
+		p.value = v
+		samples = append(samples, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, e.New(err)
+	}
+	return samples, nil
+}
+
+type promMatcher struct {
+	label string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (m promMatcher) match(labels map[string]string) bool {
+	v := labels[m.label]
+	switch m.op {
+	case "!=":
+		return v != m.value
+	case "=~":
+		return m.re.MatchString(v)
+	case "!~":
+		return !m.re.MatchString(v)
+	}
+	return v == m.value
+}
+
+// promSelector selects the series like PromQL: the metric name and the
+// label matchers =, !=, =~ and !~, as in http_requests_total{code=~"5.."}.
+type promSelector struct {
+	name     string
+	matchers []promMatcher
+}
+
+func parseSelector(s string) (*promSelector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, e.New("empty selector")
+	}
+	sel := &promSelector{}
+	i := strings.Index(s, "{")
+	if i < 0 {
+		sel.name = s
+		return sel, nil
+	}
+	sel.name = strings.TrimSpace(s[:i])
+	rest, err := promLabels(s[i:], []string{"=~", "!~", "!=", "="}, func(name, op, value string) error {
+		m := promMatcher{label: name, op: op, value: value}
+		if op == "=~" || op == "!~" {
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return e.Push(e.New(err), "invalid regexp "+value)
+			}
+			m.re = re
+		}
+		sel.matchers = append(sel.matchers, m)
+		return nil
+	})
+	if err != nil {
+		return nil, e.Push(err, "invalid selector "+s)
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, e.New("invalid selector %v", s)
+	}
+	return sel, nil
+}
+
+// series identifies the sample by its name and labels.
+func (p promSample) series() string {
+	names := make([]string, 0, len(p.labels))
+	for k := range p.labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for i, k := range names {
+		names[i] = k + "=" + strconv.Quote(p.labels[k])
+	}
+	return p.name + "{" + strings.Join(names, ",") + "}"
+}
+
+func (s *promSelector) match(p promSample) bool {
+	if s.name != "" && s.name != p.name {
+		return false
+	}
+	for _, m := range s.matchers {
+		if !m.match(p.labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckMetrics scrapes the Prometheus metrics in metrics+http:// or
+// metrics+https:// and compares the selected series with the
+// conditions. Each check has a name and the keys:
+//
+//	metric.<name> the selector, like http_requests_total{code="500"}.
+//	              The values of all series that match are summed.
+//	expect.<name> the condition, see compare, like < 1.
+//	rate.<name>   if true the value is the change per second since the
+//	              previous scrape, for counters. The rate of each
+//	              series is computed before the sum, counter resets
+//	              are handled like Prometheus does.
+//
+// The keys metric, expect and rate are a check named after the metric.
+// The request uses the keys of httpRequest and https the TLS keys of
+// tlsConfig. The url query is sent to the server.
+func CheckMetrics(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	type metricCheck struct {
+		name string
+		sel  *promSelector
+		cond string
+		rate bool
+	}
+	names := make([]string, 0)
+	for k := range opts.Prefixed("metric") {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	if opts.String("metric", "") != "" {
+		names = append([]string{""}, names...)
+	}
+	if len(names) == 0 {
+		return nil, e.New("no metric to check")
+	}
+	checks := make([]metricCheck, 0, len(names))
+	for _, name := range names {
+		key := "metric"
+		if name != "" {
+			key += "." + name
+		}
+		sel, err := parseSelector(opts.String(key, ""))
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		c := metricCheck{name: name, sel: sel}
+		if name == "" {
+			c.name = sel.name
+			c.cond = opts.String("expect", "")
+			c.rate, err = opts.Bool("rate", false)
+		} else {
+			c.cond = opts.String("expect."+name, "")
+			c.rate, err = opts.Bool("rate."+name, false)
+		}
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		checks = append(checks, c)
+	}
+
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	u := utilUrl.Copy(url)
+	u.Scheme = strings.TrimPrefix(url.Scheme, "metrics+")
+	// Without servername each redirect is verified with its own host.
+	conf, err := tlsConfig(opts, "")
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	client := &http.Client{Transport: d.Transport(conf), Timeout: budget(m)}
+	req, err := httpRequest(opts, u.String())
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, e.Push(e.New(err), "scrape failed")
+	}
+	defer resp.Body.Close()
+	body, err := httpBody(resp)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, e.New("scrape returned status code %v, expected 2xx", resp.StatusCode)
+	}
+	samples, err := parseMetrics(body)
+	if err != nil {
+		return nil, e.Push(err, "invalid metrics")
+	}
+
+	res := &Result{
+		Perf: []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}},
+	}
+	values := make([]string, 0, len(checks))
+	failed := make([]string, 0)
+	for _, c := range checks {
+		found, hasRate := false, false
+		sum, sumRate := 0.0, 0.0
+		for _, p := range samples {
+			if !c.sel.match(p) {
+				continue
+			}
+			found = true
+			sum += p.value
+			if c.rate {
+				// Per series, a reset of one isn't hidden by the others.
+				r, ok := counterRate(m, "metric."+c.name+"\x00"+p.series(), p.value)
+				if ok {
+					hasRate = true
+					sumRate += r
+				}
+			}
+		}
+		if !found {
+			failed = append(failed, c.name+" not found")
+			continue
+		}
+		value := strconv.FormatFloat(sum, 'f', -1, 64)
+		shown := value
+		perf := sum
+		if c.rate {
+			if !hasRate {
+				values = append(values, c.name+" = "+value+" (rate on the next scrape)")
+				continue
+			}
+			// The condition is checked with the exact rate, it is
+			// rounded only to be shown.
+			value = strconv.FormatFloat(sumRate, 'f', -1, 64)
+			perf = round(sumRate)
+			shown = strconv.FormatFloat(perf, 'f', -1, 64) + "/s"
+		}
+		values = append(values, c.name+" = "+shown)
+		res.Perf = append(res.Perf, Perf{Label: c.name, Value: perf})
+		if c.cond == "" {
+			continue
+		}
+		ok, err := compare(value, c.cond)
+		if err != nil {
+			return res, e.Push(err, "can't check "+c.name)
+		}
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%v = %v, expected %v", c.name, shown, c.cond))
+		}
+	}
+	res.Summary = strings.Join(values, ", ")
+	if len(failed) > 0 {
+		res.State = StateCritical
+		res.Detail = res.Summary
+		res.Summary = strings.Join(failed, ", ")
+		return res, e.New("%v", res.Summary)
+	}
+	return res, nil
+}
+
+func init() {
+	Add("metrics+http", CheckMetrics)
+	Add("metrics+https", CheckMetrics)
+}
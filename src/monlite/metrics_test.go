@@ -0,0 +1,175 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseMetrics(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []promSample
+		fail bool
+	}{
+		{"empty", "", []promSample{}, false},
+		{"comments", "# HELP up ok\n# TYPE up gauge\nup 1\n", []promSample{{"up", map[string]string{}, 1}}, false},
+		{"labels", `http_requests_total{code="200",method="GET"} 1027 1395066363000`,
+			[]promSample{{"http_requests_total", map[string]string{"code": "200", "method": "GET"}, 1027}}, false},
+		{"escapes", `msg{path="C:\\dir",text="a \"b\"\nc"} 2`,
+			[]promSample{{"msg", map[string]string{"path": `C:\dir`, "text": "a \"b\"\nc"}, 2}}, false},
+		{"trailing comma and spaces", "a{x=\"1\", } \t 3.5e2", []promSample{{"a", map[string]string{"x": "1"}, 350}}, false},
+		{"special values", "a +Inf\nb NaN\nc -1", nil, false},
+		{"empty labels", "a{} 1", []promSample{{"a", map[string]string{}, 1}}, false},
+		{"without value", "a{x=\"1\"}", nil, true},
+		{"invalid value", "a one", nil, true},
+		{"unterminated label", `a{x="1} 1`, nil, true},
+		{"label without quotes", `a{x=1} 1`, nil, true},
+		{"selector operator", `a{x!="1"} 1`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMetrics([]byte(tt.body))
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, expected %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	samples := []promSample{
+		{"http_requests_total", map[string]string{"code": "200", "method": "GET"}, 1},
+		{"http_requests_total", map[string]string{"code": "500", "method": "GET"}, 2},
+		{"http_requests_total", map[string]string{"code": "503", "method": "POST"}, 4},
+		{"up", map[string]string{"job": "api"}, 8},
+	}
+	tests := []struct {
+		selector string
+		sum      float64
+		fail     bool
+	}{
+		{"http_requests_total", 7, false},
+		{`http_requests_total{code="500"}`, 2, false},
+		{`http_requests_total{code!="200"}`, 6, false},
+		{`http_requests_total{code=~"5.."}`, 6, false},
+		{`http_requests_total{code=~"5"}`, 0, false},
+		{`http_requests_total{code!~"5..", method="GET"}`, 1, false},
+		{`{job="api"}`, 8, false},
+		{`up{missing=""}`, 8, false},
+		{"", 0, true},
+		{`up{job="api"`, 0, true},
+		{`up{job="api"} extra`, 0, true},
+		{`up{job=~"("}`, 0, true},
+		{`up{job<"a"}`, 0, true},
+	}
+	for _, tt := range tests {
+		sel, err := parseSelector(tt.selector)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("parseSelector(%q) expected an error", tt.selector)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSelector(%q): %v", tt.selector, err)
+			continue
+		}
+		sum := 0.0
+		for _, p := range samples {
+			if sel.match(p) {
+				sum += p.value
+			}
+		}
+		if sum != tt.sum {
+			t.Errorf("%v selected %v, expected %v", tt.selector, sum, tt.sum)
+		}
+	}
+}
+
+// ageSamples moves the counter samples of the monitor back by d, as if
+// the previous scrape was d ago.
+func ageSamples(m *Monitor, d time.Duration) {
+	samplesMutex.Lock()
+	defer samplesMutex.Unlock()
+	for k, s := range samples {
+		if strings.HasPrefix(k, m.Name+"\x00") {
+			s.when = s.when.Add(-d)
+			samples[k] = s
+		}
+	}
+}
+
+func TestCheckMetricsRate(t *testing.T) {
+	var mu sync.Mutex
+	body := "errors{code=\"500\"} 10\nerrors{code=\"503\"} 100\n"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(body))
+	}))
+	defer s.Close()
+	tests := []struct {
+		name   string
+		expect string
+		fail   bool
+	}{
+		{"exact rate", "> 0.005", false},
+		{"not rounded", "< 0.001", true},
+		{"not zero", "== 0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			body = "errors{code=\"500\"} 10\nerrors{code=\"503\"} 100\n"
+			mu.Unlock()
+			m := &Monitor{
+				Name:    "rate " + tt.name,
+				Url:     "metrics+" + s.URL + "/metrics",
+				Timeout: 5 * time.Second,
+				Options: Options{"metric": "errors", "rate": "true", "expect": tt.expect},
+			}
+			defer forgetSamples(m)
+			res, err := check(m)
+			if err != nil || !strings.Contains(res.Summary, "rate on the next scrape") {
+				t.Fatalf("first scrape: %v %v", res, err)
+			}
+			ageSamples(m, 1000*time.Second)
+			// 503 was reset, its increase is the new value.
+			mu.Lock()
+			body = "errors{code=\"500\"} 14\nerrors{code=\"503\"} 2\n"
+			mu.Unlock()
+			res, err = check(m)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("expected an error, result %v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(res.Summary, "errors = 0.01/s") {
+				t.Fatalf("summary %v", res.Summary)
+			}
+		})
+	}
+}
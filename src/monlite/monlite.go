@@ -5,11 +5,11 @@
 package monlite
 
 import (
+	"context"
 	"time"
 
 	"github.com/fcavani/e"
 	"github.com/fcavani/log"
-	"github.com/fcavani/ping"
 )
 
 type status uint8
@@ -26,50 +26,141 @@ type Monitor struct {
 	Periode  time.Duration
 	Sleep    time.Duration
 	Fails    int
+	Options  Options
 	OnFail   func(m *Monitor) error
 	OnUnFail func(m *Monitor) error
 	chclose  chan chan struct{}
 	count    int
 	status   status
+	result   *Result
+	err      error
+	// address, if not empty, is the ip that the dialer connects to
+	// instead of the one resolved from the url.
+	address   string
+	diagnosis string
+	// wake, if not nil, makes the monitor check before the end of the
+	// periode.
+	wake chan struct{}
 }
 
-func (m *Monitor) ping() (resp chan error) {
-	resp = make(chan error)
+// Result returns the result of the last check, it may be nil.
+func (m *Monitor) Result() *Result {
+	return m.result
+}
+
+// Err returns the error of the last check.
+func (m *Monitor) Err() error {
+	return m.err
+}
+
+// Diagnosis returns the diagnostics of the target, see Diagnose, made
+// when the monitor failed. It is empty if the key diagnose is false.
+func (m *Monitor) Diagnosis() string {
+	return m.diagnosis
+}
+
+type pong struct {
+	result *Result
+	err    error
+}
+
+func (m *Monitor) ping() (resp chan pong) {
+	resp = make(chan pong, 1)
 	go func() {
 		log.DebugLevel().Printf("Pinging %v", m.Name)
 		start := time.Now()
-		err := ping.PingRawUrl(m.Url)
+		res, err := check(m)
 		if err != nil {
 			log.Errorf("Ping failed for %v with error: %v", m.Name, e.Trace(e.Forward(err)))
-			resp <- e.Forward(err)
+			resp <- pong{res, e.Forward(err)}
 			return
 		}
 		log.DebugLevel().Printf("Ping ok for %v (%v)", m.Name, time.Since(start))
-		resp <- nil
+		resp <- pong{res, nil}
 	}()
 	return
 }
 
-func (m *Monitor) fail() {
+// fail handles a failed check. It returns true if the monitor was
+// stopped.
+func (m *Monitor) fail() (stopped bool) {
 	m.count++
 	if m.Fails != 0 || m.Fails <= m.count {
 		m.count = 0
 		m.status = statusFail
 		if m.OnFail == nil {
-			return
+			m.accept()
+			return false
+		}
+		m.diagnosis = ""
+		if m.result == nil || !m.result.changed {
+			m.diagnosis, stopped = m.diagnose()
+			if stopped {
+				return true
+			}
 		}
 		err := m.OnFail(m)
 		if err != nil {
 			log.Errorf("Onfail function on %v returned an error: %v", m.Name, err)
+		} else {
+			m.accept()
 		}
 		log.Printf("%v going to sleep for %v", m.Name, m.Sleep)
 		select {
 		case <-time.After(m.Sleep):
 		case ch := <-m.chclose:
 			ch <- struct{}{}
-			return
+			return true
+		}
+	}
+	return false
+}
+
+// diagnose runs Diagnose, it stops if the monitor is stopped.
+func (m *Monitor) diagnose() (report string, stopped bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan string, 1)
+	go func() {
+		ch <- diagnoseContext(ctx, m)
+	}()
+	select {
+	case report = <-ch:
+		return report, false
+	case stop := <-m.chclose:
+		stop <- struct{}{}
+		return "", true
+	}
+}
+
+// accept calls the accept function of the result, once.
+func (m *Monitor) accept() {
+	if m.result == nil || m.result.accept == nil {
+		return
+	}
+	err := m.result.accept()
+	m.result.accept = nil
+	if err != nil {
+		log.Errorf("Can't accept the result of %v: %v", m.Name, err)
+	}
+}
+
+// consume handles the result of a check: it calls OnUnFail if the
+// monitor is back, or fail. It returns true if the monitor was stopped.
+func (m *Monitor) consume(p pong) (stopped bool) {
+	m.result, m.err = p.result, p.err
+	if p.err != nil {
+		return m.fail()
+	}
+	if m.status != statusOk && m.OnUnFail != nil {
+		err := m.OnUnFail(m)
+		if err != nil {
+			log.Errorf("OnUnFail for %v returned an error: %v", m.Name, err)
 		}
 	}
+	m.status = statusOk
+	m.accept()
+	return false
 }
 
 func (m *Monitor) Start() error {
@@ -90,24 +181,19 @@ func (m *Monitor) Start() error {
 				ch <- struct{}{}
 				return
 			case <-time.After(m.Periode):
-				resp := m.ping()
-				select {
-				case err := <-resp:
-					if err == nil {
-						if m.status != statusOk && m.OnUnFail != nil {
-							err := m.OnUnFail(m)
-							if err != nil {
-								log.Errorf("OnUnFail for %v returned an error: %v", m.Name, err)
-							}
-						}
-						m.status = statusOk
-						continue
-					}
-					m.fail()
-					continue
-				case <-time.After(m.Timeout):
-					log.Errorf("Ping timeout for %v", m.Name)
-					m.fail()
+			case <-m.wake:
+			}
+			resp := m.ping()
+			select {
+			case p := <-resp:
+				if m.consume(p) {
+					return
+				}
+			case <-time.After(m.Timeout):
+				log.Errorf("Ping timeout for %v", m.Name)
+				m.result, m.err = nil, e.New("timeout after %v", m.Timeout)
+				if m.fail() {
+					return
 				}
 			}
 		}
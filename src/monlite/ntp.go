@@ -0,0 +1,261 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// Seconds between the NTP era (1900) and the unix epoch.
+const ntpEpoch = 2208988800
+
+type ntpSample struct {
+	Offset  time.Duration
+	Delay   time.Duration
+	Stratum int
+	Leap    int
+	RefID   string
+}
+
+// ntpTime converts a NTP timestamp, era 0.
+func ntpTime(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b[:4])) - ntpEpoch
+	frac := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(sec, frac*1e9>>32)
+}
+
+// ntpQuery sends one SNTPv4 request to host with the dialer. The
+// transmit timestamp of the request is random, the server must echo it.
+func ntpQuery(d *Dialer, host string, timeout time.Duration) (*ntpSample, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "123")
+	}
+	conn, err := d.Dial("udp", host)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3 // version 4, client
+	nonce := req[40:48]
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	t1 := time.Now()
+	_, err = conn.Write(req)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	resp := make([]byte, 128)
+	for {
+		n, err := conn.Read(resp)
+		if err != nil {
+			return nil, e.New(err)
+		}
+		// Ignore short or spoofed replies.
+		if n >= 48 && bytes.Equal(resp[24:32], nonce) {
+			break
+		}
+	}
+	t4 := time.Now()
+
+	if mode := resp[0] & 7; mode != 4 {
+		return nil, e.New("invalid mode %v in the reply", mode)
+	}
+	s := &ntpSample{
+		Leap:    int(resp[0] >> 6),
+		Stratum: int(resp[1]),
+	}
+	if s.Stratum == 0 {
+		// Kiss-o'-Death, the reference id is the code.
+		return nil, e.New("kiss of death %v", strings.TrimRight(string(resp[12:16]), "\x00"))
+	}
+	if s.Stratum == 1 {
+		s.RefID = strings.TrimRight(string(resp[12:16]), "\x00")
+	} else {
+		s.RefID = net.IP(resp[12:16]).String()
+	}
+	t2 := ntpTime(resp[32:40])
+	t3 := ntpTime(resp[40:48])
+	s.Offset = (t2.Sub(t1) + t3.Sub(t4)) / 2
+	s.Delay = t4.Sub(t1) - t3.Sub(t2)
+	return s, nil
+}
+
+// ntpBest sends samples requests and returns the one with the lowest
+// delay, the more accurate. The requests share the time until deadline,
+// a lost reply doesn't use the time of the next ones.
+func ntpBest(d *Dialer, host string, samples int, deadline time.Time) (*ntpSample, error) {
+	var best *ntpSample
+	last := e.New("timeout")
+	for i := 0; i < samples; i++ {
+		timeout := time.Until(deadline) / time.Duration(samples-i)
+		if timeout <= 0 {
+			break
+		}
+		s, err := ntpQuery(d, host, timeout)
+		if err != nil {
+			last = err
+			continue
+		}
+		if best == nil || s.Delay < best.Delay {
+			best = s
+		}
+	}
+	if best == nil {
+		return nil, e.Forward(last)
+	}
+	return best, nil
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// CheckNtp queries the server in ntp://host[:port] with SNTPv4 and
+// computes the offset of the local clock and the round trip delay. It
+// fails if the server is unsynchronized (stratum 16 or leap indicator
+// alarm) or the offset is above the threshold. The keys are:
+//
+//	offset  maximum offset (1s).
+//	warn    offset of the warning, zero to disable.
+//	samples number of requests, the one with the lowest delay is used
+//	        (1).
+//	peers   comma separated list of other servers to compare with.
+//	spread  maximum difference between the offsets of the server and
+//	        the peers (offset). Peers that don't answer are ignored.
+//
+// The server and the peers are queried at the same time, the samples of
+// each one share the monitor timeout.
+func CheckNtp(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	max, err := opts.Duration("offset", time.Second)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	warn, err := opts.Duration("warn", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	spread, err := opts.Duration("spread", max)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	samples, err := opts.Int("samples", 1)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if samples < 1 {
+		samples = 1
+	}
+
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	deadline := time.Now().Add(budget(m))
+	peers := opts.List("peers")
+	type peerSample struct {
+		s   *ntpSample
+		err error
+	}
+	replies := make([]chan peerSample, len(peers))
+	// The peers are resolved, not the address of the server.
+	pd := &Dialer{Timeout: budget(m), Source: d.Source, Interface: d.Interface}
+	for i, p := range peers {
+		replies[i] = make(chan peerSample, 1)
+		go func(p string, ch chan peerSample) {
+			s, err := ntpBest(pd, p, samples, deadline)
+			ch <- peerSample{s, err}
+		}(p, replies[i])
+	}
+	s, err := ntpBest(d, url.Host, samples, deadline)
+	if err != nil {
+		return nil, e.Push(err, "query failed")
+	}
+	ms := func(d time.Duration) float64 {
+		return round(d.Seconds() * 1000)
+	}
+	perfMs := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return fmt.Sprint(ms(d))
+	}
+	res := &Result{
+		Summary: fmt.Sprintf("offset %v, delay %v, stratum %v", s.Offset, s.Delay, s.Stratum),
+		Perf: []Perf{
+			{Label: "offset", Value: ms(s.Offset), Unit: "ms", Warn: perfMs(warn), Crit: perfMs(max)},
+			{Label: "delay", Value: ms(s.Delay), Unit: "ms"},
+			{Label: "stratum", Value: float64(s.Stratum), Min: "0", Max: "16"},
+		},
+		Detail: "reference " + s.RefID,
+	}
+
+	if s.Stratum >= 16 {
+		res.State = StateCritical
+		return res, e.New("server unsynchronized (stratum %v)", s.Stratum)
+	}
+	if s.Leap == 3 {
+		res.State = StateCritical
+		return res, e.New("server unsynchronized (leap indicator alarm)")
+	}
+
+	if len(peers) > 0 {
+		low, high := s.Offset, s.Offset
+		lines := make([]string, 0, len(peers))
+		for i, p := range peers {
+			r := <-replies[i]
+			if r.err != nil {
+				lines = append(lines, fmt.Sprintf("%v: %v", p, r.err))
+				continue
+			}
+			ps := r.s
+			lines = append(lines, fmt.Sprintf("%v: offset %v, delay %v, stratum %v", p, ps.Offset, ps.Delay, ps.Stratum))
+			res.Perf = append(res.Perf, Perf{Label: "offset_" + p, Value: ms(ps.Offset), Unit: "ms"})
+			if ps.Offset < low {
+				low = ps.Offset
+			}
+			if ps.Offset > high {
+				high = ps.Offset
+			}
+		}
+		res.Detail += "\n\npeers:\n" + strings.Join(lines, "\n")
+		res.Perf = append(res.Perf, Perf{Label: "spread", Value: ms(high - low), Unit: "ms", Crit: perfMs(spread)})
+		if high-low > spread {
+			res.State = StateCritical
+			return res, e.New("servers disagree by %v, more than %v", high-low, spread)
+		}
+	}
+
+	switch {
+	case abs(s.Offset) > max:
+		res.State = StateCritical
+		return res, e.New("offset %v above %v", s.Offset, max)
+	case warn > 0 && abs(s.Offset) > warn:
+		res.State = StateWarning
+		return res, e.New("offset %v above %v", s.Offset, warn)
+	}
+	return res, nil
+}
+
+func init() {
+	Add("ntp", CheckNtp)
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestNtpTime(t *testing.T) {
+	tests := []struct {
+		sec, frac uint32
+		want      time.Time
+	}{
+		{ntpEpoch, 0, time.Unix(0, 0)},
+		{ntpEpoch + 1, 1 << 31, time.Unix(1, 5e8)},
+		{3913056000, 1 << 30, time.Unix(3913056000-ntpEpoch, 25e7)},
+	}
+	for _, tt := range tests {
+		b := binary.BigEndian.AppendUint32(nil, tt.sec)
+		b = binary.BigEndian.AppendUint32(b, tt.frac)
+		if got := ntpTime(b); !got.Equal(tt.want) {
+			t.Errorf("ntpTime(%v, %v) = %v, expected %v", tt.sec, tt.frac, got, tt.want)
+		}
+	}
+}
+
+// fakeNTP is an in-process SNTP server with the clock ahead by offset.
+// It drops the first drop requests and, if silent, all of them.
+func fakeNTP(t *testing.T, offset time.Duration, stratum byte, drop int, silent bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	put := func(b []byte, tm time.Time) {
+		tm = tm.Add(offset)
+		binary.BigEndian.PutUint32(b, uint32(tm.Unix()+ntpEpoch))
+		binary.BigEndian.PutUint32(b[4:], uint32((int64(tm.Nanosecond())<<32)/1e9))
+	}
+	go func() {
+		req := make([]byte, 128)
+		for {
+			n, addr, err := conn.ReadFrom(req)
+			if err != nil {
+				return
+			}
+			if silent || n < 48 {
+				continue
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+			received := time.Now()
+			resp := make([]byte, 48)
+			resp[0] = 4<<3 | 4 // version 4, server
+			resp[1] = stratum
+			copy(resp[12:16], "GPS")
+			copy(resp[24:32], req[40:48])
+			put(resp[32:40], received)
+			put(resp[40:48], time.Now())
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestCheckNtp(t *testing.T) {
+	good := fakeNTP(t, 0, 1, 0, false)
+	ahead := fakeNTP(t, 3*time.Second, 1, 0, false)
+	silent := fakeNTP(t, 0, 1, 0, true)
+	tests := []struct {
+		name   string
+		server string
+		opts   Options
+		err    string
+	}{
+		{"synchronized", good, nil, ""},
+		{"offset above", ahead, nil, "offset"},
+		{"offset below", ahead, Options{"offset": "5"}, ""},
+		{"warning", ahead, Options{"offset": "5", "warn": "2"}, "above 2s"},
+		{"unsynchronized", fakeNTP(t, 0, 16, 0, false), nil, "unsynchronized"},
+		{"kiss of death", fakeNTP(t, 0, 0, 0, false), nil, "kiss of death"},
+		{"lost reply", fakeNTP(t, 0, 1, 1, false), Options{"samples": "3"}, ""},
+		{"silent server", silent, nil, "query failed"},
+		{"silent peer", good, Options{"peers": silent + "," + good}, ""},
+		{"peers disagree", good, Options{"peers": ahead}, "disagree"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: "ntp://" + tt.server, Timeout: time.Second, Options: tt.opts}
+			start := time.Now()
+			res, err := check(m)
+			if time.Since(start) >= m.Timeout {
+				t.Fatalf("took %v, more than the monitor timeout", time.Since(start))
+			}
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+		})
+	}
+}
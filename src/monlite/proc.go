@@ -0,0 +1,278 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fcavani/e"
+)
+
+// CheckProc checks if a process without network port is alive.
+//
+//	proc://pidfile/var/run/crond.pid  the pid in the file must be
+//	                                  running. If the key cmdline is
+//	                                  set the process command line
+//	                                  must match it (regexp).
+//	proc://match                      counts the processes whose
+//	                                  command line match the regexp in
+//	                                  the key regex (the key field
+//	                                  name matches the process name
+//	                                  instead). There must be at least
+//	                                  min (1) and at most max
+//	                                  processes, max 0 is no limit
+//	                                  (default).
+//
+// For both forms the key rss is the maximum resident memory, in MB, of
+// each process, the key fds the maximum number of open files and if
+// the key restart is true the monitor fails when the oldest process,
+// the master of the workers, changes. The workers may come and go.
+func CheckProc(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	var procs []*process
+	var err error
+	switch url.Host {
+	case "pidfile":
+		procs, err = pidfileProcess(url.Path, opts.String("cmdline", ""))
+	case "match":
+		procs, err = matchProcesses(opts)
+	default:
+		return nil, e.New("unknown proc check %v", url.Host)
+	}
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+
+	res := &Result{Summary: fmt.Sprintf("%v processes running", len(procs))}
+	res.Perf = append(res.Perf, Perf{Label: "procs", Value: float64(len(procs))})
+	failed := make([]string, 0)
+
+	rss, err := opts.Float("rss", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	fds, err := opts.Int("fds", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	for _, p := range procs {
+		if rss > 0 {
+			mb, err := p.rss()
+			if err != nil {
+				return nil, e.Forward(err)
+			}
+			res.Perf = append(res.Perf, Perf{Label: fmt.Sprintf("rss_%v", p.pid), Value: round(mb), Unit: "MB"})
+			if mb > rss {
+				failed = append(failed, fmt.Sprintf("%v (%v) uses %.1f MB", p.name, p.pid, mb))
+			}
+		}
+		if fds > 0 {
+			n, err := p.fds()
+			if err != nil {
+				return nil, e.Forward(err)
+			}
+			res.Perf = append(res.Perf, Perf{Label: fmt.Sprintf("fds_%v", p.pid), Value: float64(n)})
+			if n > fds {
+				failed = append(failed, fmt.Sprintf("%v (%v) has %v open files", p.name, p.pid, n))
+			}
+		}
+	}
+
+	restart, err := opts.Bool("restart", false)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if restart && len(procs) > 0 {
+		oldest := oldestProcess(procs)
+		now := fmt.Sprintf("%v (%v) started at %v", oldest.name, oldest.pid, oldest.start)
+		prev, found := remember(m, "start", now)
+		if found && prev != now {
+			failed = append(failed, "process restarted")
+			res.Detail = "before: " + prev + "\nnow: " + now
+		}
+	}
+
+	if len(failed) > 0 {
+		res.State = StateCritical
+		res.Summary = strings.Join(failed, ", ")
+		return res, e.New("%v", res.Summary)
+	}
+	return res, nil
+}
+
+type process struct {
+	pid     int
+	name    string
+	state   string
+	start   string
+	cmdline string
+}
+
+// oldestProcess returns the process that started first, the lowest pid
+// if they started in the same clock tick.
+func oldestProcess(procs []*process) *process {
+	sorted := make([]*process, len(procs))
+	copy(sorted, procs)
+	sort.Slice(sorted, func(i, j int) bool {
+		si, _ := strconv.ParseUint(sorted[i].start, 10, 64)
+		sj, _ := strconv.ParseUint(sorted[j].start, 10, 64)
+		if si != sj {
+			return si < sj
+		}
+		return sorted[i].pid < sorted[j].pid
+	})
+	return sorted[0]
+}
+
+func readProcess(pid int) (*process, error) {
+	dir := fmt.Sprintf("%v/%v", ProcDir, pid)
+	stat, err := ioutil.ReadFile(dir + "/stat")
+	if err != nil {
+		return nil, e.New(err)
+	}
+	// pid (comm) state ppid ... the comm may have spaces and parentheses.
+	open := bytes.IndexByte(stat, '(')
+	end := bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return nil, e.New("invalid stat for %v", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return nil, e.New("invalid stat for %v", pid)
+	}
+	cmdline, err := ioutil.ReadFile(dir + "/cmdline")
+	if err != nil {
+		return nil, e.New(err)
+	}
+	return &process{
+		pid:     pid,
+		name:    string(stat[open+1 : end]),
+		state:   fields[0],
+		start:   fields[19],
+		cmdline: strings.TrimSpace(string(bytes.Replace(cmdline, []byte{0}, []byte{' '}, -1))),
+	}, nil
+}
+
+func (p *process) rss() (float64, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("%v/%v/status", ProcDir, p.pid))
+	if err != nil {
+		return 0, e.New(err)
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			break
+		}
+		kb, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, e.New(err)
+		}
+		return kb / 1024, nil
+	}
+	return 0, nil
+}
+
+func (p *process) fds() (int, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf("%v/%v/fd", ProcDir, p.pid))
+	if err != nil {
+		return 0, e.New(err)
+	}
+	return len(files), nil
+}
+
+func pidfileProcess(pidfile, cmdline string) ([]*process, error) {
+	data, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, e.Push(e.New(err), "invalid pid file "+pidfile)
+	}
+	p, err := readProcess(pid)
+	if err != nil {
+		return nil, e.Push(err, fmt.Sprintf("process %v from %v isn't running", pid, pidfile))
+	}
+	if p.state == "Z" {
+		return nil, e.New("process %v from %v is a zombie", pid, pidfile)
+	}
+	if cmdline != "" {
+		re, err := regexp.Compile(cmdline)
+		if err != nil {
+			return nil, e.Push(e.New(err), "invalid cmdline")
+		}
+		if !re.MatchString(p.cmdline) {
+			return nil, e.New("process %v command line is %v", pid, p.cmdline)
+		}
+	}
+	return []*process{p}, nil
+}
+
+func matchProcesses(opts Options) ([]*process, error) {
+	regex := opts.String("regex", "")
+	if regex == "" {
+		return nil, e.New("empty regex")
+	}
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, e.Push(e.New(err), "invalid regex")
+	}
+	field := opts.String("field", "cmdline")
+	if field != "cmdline" && field != "name" {
+		return nil, e.New("field must be cmdline or name")
+	}
+	min, err := opts.Int("min", 1)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	max, err := opts.Int("max", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	entries, err := ioutil.ReadDir(ProcDir)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	procs := make([]*process, 0)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		p, err := readProcess(pid)
+		if err != nil || p.state == "Z" {
+			// Gone while reading or a zombie.
+			continue
+		}
+		s := p.cmdline
+		if field == "name" {
+			s = p.name
+		}
+		if re.MatchString(s) {
+			procs = append(procs, p)
+		}
+	}
+	if len(procs) < min {
+		return procs, e.New("%v processes match %v, expected at least %v", len(procs), re, min)
+	}
+	if max > 0 && len(procs) > max {
+		return procs, e.New("%v processes match %v, expected at most %v", len(procs), re, max)
+	}
+	return procs, nil
+}
+
+func init() {
+	Add("proc", CheckProc)
+}
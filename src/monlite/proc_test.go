@@ -0,0 +1,119 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fcavani/e"
+)
+
+// procFiles are the /proc files of a process, the start time is in
+// clock ticks after the boot.
+func procFiles(files map[string]string, pid int, name, state string, start int, cmdline string, rssKB, fds int) {
+	dir := fmt.Sprint(pid)
+	// pid (comm) state and 19 fields until the start time.
+	files[dir+"/stat"] = fmt.Sprintf("%v (%v) %v 1 %v%v 0 0 0\n", pid, name, state, strings.Repeat("0 ", 17), start)
+	files[dir+"/cmdline"] = strings.Replace(cmdline, " ", "\x00", -1) + "\x00"
+	files[dir+"/status"] = fmt.Sprintf("Name:\t%v\nVmRSS:\t%v kB\n", name, rssKB)
+	for i := 0; i < fds; i++ {
+		files[fmt.Sprintf("%v/fd/%v", dir, i)] = ""
+	}
+}
+
+func TestCheckProc(t *testing.T) {
+	files := map[string]string{}
+	procFiles(files, 100, "nginx", "S", 1000, "nginx: master process /usr/sbin/nginx", 4096, 3)
+	procFiles(files, 101, "nginx", "S", 1010, "nginx: worker process", 8192, 10)
+	procFiles(files, 102, "nginx", "S", 1010, "nginx: worker process", 8192, 10)
+	procFiles(files, 103, "nginx", "Z", 1020, "", 0, 0)
+	procFiles(files, 200, "crond", "S", 500, "/usr/sbin/crond -n", 1024, 3)
+	procFixture(t, files)
+	pidfile := filepath.Join(t.TempDir(), "crond.pid")
+	if err := ioutil.WriteFile(pidfile, []byte("200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zombie := filepath.Join(t.TempDir(), "zombie.pid")
+	if err := ioutil.WriteFile(zombie, []byte("103\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gone := filepath.Join(t.TempDir(), "gone.pid")
+	if err := ioutil.WriteFile(gone, []byte("999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		url  string
+		opts Options
+		err  string
+	}{
+		{"pidfile", "proc://pidfile" + pidfile, Options{"cmdline": "crond"}, ""},
+		{"pidfile cmdline", "proc://pidfile" + pidfile, Options{"cmdline": "^/usr/sbin/atd"}, "process 200 command line is /usr/sbin/crond -n"},
+		{"pidfile zombie", "proc://pidfile" + zombie, nil, "process 103 from " + zombie + " is a zombie"},
+		{"pidfile gone", "proc://pidfile" + gone, nil, "process 999 from " + gone + " isn't running"},
+		{"match", "proc://match", Options{"regex": "^nginx: worker", "min": "2"}, ""},
+		{"match min", "proc://match", Options{"regex": "^nginx: worker", "min": "3"}, "2 processes match ^nginx: worker, expected at least 3"},
+		{"match max", "proc://match", Options{"regex": "^nginx", "max": "2"}, "3 processes match ^nginx, expected at most 2"},
+		{"match without limit", "proc://match", Options{"regex": "^nginx", "max": "0"}, ""},
+		{"match name", "proc://match", Options{"regex": "^crond$", "field": "name"}, ""},
+		{"rss", "proc://match", Options{"regex": "^nginx", "rss": "6"}, "nginx (101) uses 8.0 MB, nginx (102) uses 8.0 MB"},
+		{"fds", "proc://match", Options{"regex": "^nginx", "fds": "5"}, "nginx (101) has 10 open files"},
+		{"unknown", "proc://pid", nil, "unknown proc check pid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: "proc " + tt.name, Url: tt.url, Options: tt.opts}
+			_, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+			} else if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckProcRestart(t *testing.T) {
+	m := &Monitor{Name: "proc restart", Url: "proc://match", Options: Options{"regex": "^nginx", "restart": "true"}}
+	t.Cleanup(func() { forgetSamples(m) })
+	run := func(procs func(files map[string]string)) error {
+		files := map[string]string{}
+		procs(files)
+		procFixture(t, files)
+		_, err := check(m)
+		return err
+	}
+	err := run(func(files map[string]string) {
+		procFiles(files, 100, "nginx", "S", 1000, "nginx: master", 0, 0)
+		procFiles(files, 101, "nginx", "S", 1010, "nginx: worker", 0, 0)
+		procFiles(files, 102, "nginx", "S", 1010, "nginx: worker", 0, 0)
+	})
+	if err != nil {
+		t.Fatalf("first check: %v", err)
+	}
+	// A worker was replaced.
+	err = run(func(files map[string]string) {
+		procFiles(files, 100, "nginx", "S", 1000, "nginx: master", 0, 0)
+		procFiles(files, 101, "nginx", "S", 1010, "nginx: worker", 0, 0)
+		procFiles(files, 150, "nginx", "S", 3000, "nginx: worker", 0, 0)
+	})
+	if err != nil {
+		t.Fatalf("worker churn reported: %v", err)
+	}
+	// The master restarted.
+	err = run(func(files map[string]string) {
+		procFiles(files, 300, "nginx", "S", 5000, "nginx: master", 0, 0)
+		procFiles(files, 301, "nginx", "S", 5010, "nginx: worker", 0, 0)
+	})
+	if err == nil || !strings.Contains(e.Trace(err), "process restarted") {
+		t.Fatalf("master restart: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// State is the state of the service found by a checker. The values
+// are the same of the Nagios plugins return codes.
+type State uint8
+
+const (
+	StateOk State = iota
+	StateWarning
+	StateCritical
+	StateUnknown
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOk:
+		return "OK"
+	case StateWarning:
+		return "WARNING"
+	case StateCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Perf is one performance data value in the Nagios format:
+// 'label'=value[UOM];[warn];[crit];[min];[max]
+type Perf struct {
+	Label string
+	Value float64
+	Unit  string
+	Warn  string
+	Crit  string
+	Min   string
+	Max   string
+}
+
+func (p Perf) String() string {
+	label := p.Label
+	if strings.ContainsAny(label, " ='") {
+		label = "'" + strings.Replace(label, "'", "''", -1) + "'"
+	}
+	s := label + "=" + strconv.FormatFloat(p.Value, 'f', -1, 64) + p.Unit
+	tail := strings.TrimRight(strings.Join([]string{p.Warn, p.Crit, p.Min, p.Max}, ";"), ";")
+	if tail != "" {
+		s += ";" + tail
+	}
+	return s
+}
+
+// Result is what the checker found about the service. Detail is
+// attached to the alert.
+type Result struct {
+	State   State
+	Summary string
+	Perf    []Perf
+	Detail  string
+	// accept, if not nil, stores what the check compared with, like
+	// the baseline of contentChange. The monitor calls it once the
+	// result is consumed.
+	accept func() error
+	// changed is true if the check failed because the content
+	// changed, the alert needs no diagnostics.
+	changed bool
+}
+
+func (r *Result) String() string {
+	if r == nil {
+		return ""
+	}
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "%v: %v", r.State, r.Summary)
+	if len(r.Perf) > 0 {
+		perf := make([]string, len(r.Perf))
+		for i, p := range r.Perf {
+			perf[i] = p.String()
+		}
+		fmt.Fprintf(buf, " | %v", strings.Join(perf, " "))
+	}
+	if r.Detail != "" {
+		fmt.Fprintf(buf, "\n\n%v", r.Detail)
+	}
+	return buf.String()
+}
+
+// ParsePerf parses performance data in the Nagios format. Malformed
+// values are skipped.
+func ParsePerf(s string) []Perf {
+	perfs := make([]Perf, 0)
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return perfs
+		}
+		var label string
+		if s[0] == '\'' {
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						label += "'"
+						i++
+						continue
+					}
+					break
+				}
+				label += string(s[i])
+			}
+			if i+1 >= len(s) || s[i+1] != '=' {
+				return perfs
+			}
+			s = s[i+2:]
+		} else {
+			i := strings.Index(s, "=")
+			if i < 0 {
+				return perfs
+			}
+			label = s[:i]
+			s = s[i+1:]
+		}
+		var field string
+		if i := strings.IndexAny(s, " \t"); i >= 0 {
+			field, s = s[:i], s[i:]
+		} else {
+			field, s = s, ""
+		}
+		p, ok := parsePerfValue(label, field)
+		if ok {
+			perfs = append(perfs, p)
+		}
+	}
+}
+
+func parsePerfValue(label, field string) (Perf, bool) {
+	p := Perf{Label: label}
+	parts := strings.Split(field, ";")
+	v := parts[0]
+	i := strings.IndexFunc(v, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E')
+	})
+	if i >= 0 {
+		p.Unit = v[i:]
+		v = v[:i]
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return p, false
+	}
+	p.Value = f
+	dst := []*string{&p.Warn, &p.Crit, &p.Min, &p.Max}
+	for i, part := range parts[1:] {
+		if i >= len(dst) {
+			break
+		}
+		*dst[i] = part
+	}
+	return p, true
+}
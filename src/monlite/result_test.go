@@ -0,0 +1,59 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePerf(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Perf
+	}{
+		{"", []Perf{}},
+		{"time=0.5s", []Perf{{Label: "time", Value: 0.5, Unit: "s"}}},
+		{"load1=1.2;2;4;0 load5=0.8",
+			[]Perf{{Label: "load1", Value: 1.2, Warn: "2", Crit: "4", Min: "0"}, {Label: "load5", Value: 0.8}}},
+		{"/=2643MB;5948;5958;0;5968", []Perf{{Label: "/", Value: 2643, Unit: "MB", Warn: "5948", Crit: "5958", Min: "0", Max: "5968"}}},
+		{"used=95%;80:90;@10:20", []Perf{{Label: "used", Value: 95, Unit: "%", Warn: "80:90", Crit: "@10:20"}}},
+		{"'disk space /var'=10GB", []Perf{{Label: "disk space /var", Value: 10, Unit: "GB"}}},
+		{"'it''s'=1c", []Perf{{Label: "it's", Value: 1, Unit: "c"}}},
+		{"neg=-1.5e3 \t pos=+2", []Perf{{Label: "neg", Value: -1500}, {Label: "pos", Value: 2}}},
+		{"bad=U ok=1", []Perf{{Label: "ok", Value: 1}}},
+		{"a=1;2;3;4;5;6", []Perf{{Label: "a", Value: 1, Warn: "2", Crit: "3", Min: "4", Max: "5"}}},
+		{"a=1 garbage", []Perf{{Label: "a", Value: 1}}},
+		{"'unterminated=1", []Perf{}},
+	}
+	for _, tt := range tests {
+		got := ParsePerf(tt.in)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePerf(%q) = %#v, expected %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPerfString(t *testing.T) {
+	tests := []struct {
+		perf Perf
+		want string
+	}{
+		{Perf{Label: "time", Value: 12.5, Unit: "ms"}, "time=12.5ms"},
+		{Perf{Label: "load", Value: 1, Warn: "2", Crit: "4"}, "load=1;2;4"},
+		{Perf{Label: "listed", Value: 0, Min: "0"}, "listed=0;;;0"},
+		{Perf{Label: "it's used", Value: 3}, "'it''s used'=3"},
+	}
+	for _, tt := range tests {
+		got := tt.perf.String()
+		if got != tt.want {
+			t.Errorf("%#v.String() = %q, expected %q", tt.perf, got, tt.want)
+		}
+		back := ParsePerf(got)
+		if len(back) != 1 || !reflect.DeepEqual(back[0], tt.perf) {
+			t.Errorf("ParsePerf(%q) = %#v, expected %#v", got, back, tt.perf)
+		}
+	}
+}
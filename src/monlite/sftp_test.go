@@ -0,0 +1,271 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSftpSkipAttrs(t *testing.T) {
+	u32 := func(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+	join := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+	tests := []struct {
+		name  string
+		attrs []byte
+		fail  bool
+	}{
+		{"no attributes", u32(0), false},
+		{"size", join(u32(0x1), make([]byte, 8)), false},
+		{"all", join(u32(0xf), make([]byte, 8+8+4+8)), false},
+		{"extended", join(u32(0x80000001), make([]byte, 8), u32(1), sftpAppendString(nil, []byte("k")), sftpAppendString(nil, []byte("v"))), false},
+		{"short size", join(u32(0x1), make([]byte, 2)), true},
+		{"short extended", join(u32(0x80000000), u32(1), sftpAppendString(nil, []byte("k"))), true},
+		{"no flags", []byte{0, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, err := sftpSkipAttrs(append(tt.attrs, "next"...))
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("expected an error, rest %q", rest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(rest) != "next" {
+				t.Fatalf("rest %q", rest)
+			}
+		})
+	}
+}
+
+// fakeSFTP is an in-process ssh server, for the user with the password
+// secret, with a SFTP version 3 subsystem over files in memory.
+type fakeSFTP struct {
+	addr        string
+	fingerprint string
+	mu          sync.Mutex
+	files       map[string][]byte
+	stored      []string
+}
+
+func newFakeSFTP(t *testing.T) *fakeSFTP {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "user" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, io.ErrUnexpectedEOF
+		},
+	}
+	config.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeSFTP{
+		addr:        ln.Addr().String(),
+		fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		files:       map[string][]byte{"/upload/readme.txt": []byte("hi")},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn, config)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSFTP) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go f.subsystem(ch)
+				}
+			}
+		}()
+	}
+}
+
+func (f *fakeSFTP) subsystem(ch ssh.Channel) {
+	defer ch.Close()
+	c := &sftpConn{w: ch, r: ch}
+	typ, _, err := c.recv()
+	if err != nil || typ != sftpInit {
+		return
+	}
+	c.send(sftpVersion, binary.BigEndian.AppendUint32(nil, 3))
+	handles := make(map[string]string)
+	listed := make(map[string]bool)
+	for {
+		typ, payload, err := c.recv()
+		if err != nil {
+			return
+		}
+		id := append([]byte(nil), payload[:4]...)
+		payload = payload[4:]
+		status := func(code uint32) {
+			reply := append(binary.BigEndian.AppendUint32(id, code), 0, 0, 0, 0, 0, 0, 0, 0)
+			c.send(sftpStatus, reply)
+		}
+		str := func() string {
+			s, rest, _ := sftpString(payload)
+			payload = rest
+			return string(s)
+		}
+		f.mu.Lock()
+		switch typ {
+		case sftpOpendir, sftpOpen:
+			name := str()
+			if typ == sftpOpen && binary.BigEndian.Uint32(payload)&sftpFlagWrite != 0 {
+				f.files[name] = nil
+				f.stored = append(f.stored, name)
+			}
+			h := "h" + name
+			handles[h] = name
+			c.send(sftpHandle, sftpAppendString(id, []byte(h)))
+		case sftpReaddir:
+			h := str()
+			if listed[h] {
+				status(sftpEOF)
+				break
+			}
+			listed[h] = true
+			names := make([]string, 0)
+			for name := range f.files {
+				if path.Dir(name) == handles[h] {
+					names = append(names, path.Base(name))
+				}
+			}
+			sort.Strings(names)
+			reply := binary.BigEndian.AppendUint32(id, uint32(len(names)))
+			for _, n := range names {
+				reply = sftpAppendString(reply, []byte(n))
+				reply = sftpAppendString(reply, []byte("-rw-r--r-- 1 user user 2 Jan 1 00:00 "+n))
+				reply = binary.BigEndian.AppendUint32(reply, 0)
+			}
+			c.send(sftpName, reply)
+		case sftpWrite:
+			name := handles[str()]
+			off := binary.BigEndian.Uint64(payload)
+			payload = payload[8:]
+			data := str()
+			f.files[name] = append(f.files[name][:off], data...)
+			status(sftpStatusOk)
+		case sftpRead:
+			data := f.files[handles[str()]]
+			off := binary.BigEndian.Uint64(payload)
+			if off >= uint64(len(data)) {
+				status(sftpEOF)
+				break
+			}
+			c.send(sftpData, sftpAppendString(id, data[off:]))
+		case sftpClose:
+			delete(handles, str())
+			status(sftpStatusOk)
+		case sftpRemove:
+			delete(f.files, str())
+			status(sftpStatusOk)
+		default:
+			status(8) // SSH_FX_OP_UNSUPPORTED
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestCheckSFTP(t *testing.T) {
+	f := newFakeSFTP(t)
+	tests := []struct {
+		name string
+		user string
+		opts Options
+		fail bool
+	}{
+		{"list", "user:secret@", Options{"fingerprint": f.fingerprint}, false},
+		{"transfer", "user:secret@", Options{"fingerprint": f.fingerprint, "transfer": "true"}, false},
+		{"wrong password", "user:wrong@", Options{"fingerprint": f.fingerprint}, true},
+		{"wrong fingerprint", "user:secret@", Options{"fingerprint": "SHA256:other"}, true},
+		{"without password", "user@", Options{"fingerprint": f.fingerprint}, true},
+		{"unverified host key", "user:secret@", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: "sftp://" + tt.user + f.addr + "/upload", Timeout: 5 * time.Second, Options: tt.opts}
+			res, err := check(m)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("expected an error, result %v", res)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(res.Detail, "readme.txt") {
+				t.Fatalf("listing without the file: %v", res.Detail)
+			}
+			if tt.opts["transfer"] == "true" {
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				if len(f.stored) != 1 || !strings.HasPrefix(f.stored[0], "/upload/monlite-") {
+					t.Fatalf("stored %v", f.stored)
+				}
+				if _, ok := f.files[f.stored[0]]; ok {
+					t.Fatal("the file wasn't removed")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net"
+	"net/smtp"
+	"net/url"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// CheckSMTP connects to smtp://[user:password@]host[:port] through the
+// dialer of the monitor, says hello (the key hello), starts TLS if the
+// server has STARTTLS, authenticates if the url has an user and resets
+// the session. Like package ping, the server certificate isn't verified
+// unless the key insecure is false; the other TLS keys are the ones of
+// tlsConfig.
+func CheckSMTP(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	addr := url.Host
+	if url.Port() == "" {
+		addr = net.JoinHostPort(url.Hostname(), "25")
+	}
+	conf, err := tlsConfig(opts, url.Hostname())
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	if _, ok := opts["insecure"]; !ok {
+		conf.InsecureSkipVerify = true
+	}
+
+	start := time.Now()
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(budget(m)))
+	c, err := smtp.NewClient(conn, url.Hostname())
+	if err != nil {
+		return nil, e.Push(e.New(err), "invalid greeting")
+	}
+	defer c.Close()
+	if hello := opts.String("hello", ""); hello != "" {
+		err = c.Hello(hello)
+		if err != nil {
+			return nil, e.Push(e.New(err), "hello failed")
+		}
+	}
+	res := &Result{Summary: "smtp server " + url.Hostname()}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(conf)
+		if err != nil {
+			return nil, e.Push(e.New(err), "starttls failed")
+		}
+		res.Summary += " with STARTTLS"
+	}
+	if url.User != nil {
+		pass, ok := url.User.Password()
+		if ok && url.User.Username() != "" && pass != "" {
+			if found, _ := c.Extension("AUTH"); found {
+				err = c.Auth(smtp.PlainAuth("", url.User.Username(), pass, url.Hostname()))
+				if err != nil {
+					res.State = StateCritical
+					return res, e.Push(e.New(err), "authentication failed")
+				}
+			}
+		}
+	}
+	err = c.Reset()
+	if err != nil {
+		return res, e.Push(e.New(err), "reset failed")
+	}
+	c.Quit()
+	res.Perf = []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}}
+	return res, nil
+}
+
+func init() {
+	Add("smtp", CheckSMTP)
+}
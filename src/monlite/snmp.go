@@ -0,0 +1,341 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fcavani/e"
+	"github.com/gosnmp/gosnmp"
+)
+
+type snmpObject struct {
+	oid  string
+	enum map[int]string
+}
+
+var ifStatus = map[int]string{
+	1: "up",
+	2: "down",
+	3: "testing",
+	4: "unknown",
+	5: "dormant",
+	6: "notPresent",
+	7: "lowerLayerDown",
+}
+
+// snmpNames are the names of some common objects, so the urls and the
+// keys can use ifOperStatus.2 instead of 1.3.6.1.2.1.2.2.1.8.2.
+var snmpNames = map[string]snmpObject{
+	"sysDescr":                     {oid: "1.3.6.1.2.1.1.1"},
+	"sysUpTime":                    {oid: "1.3.6.1.2.1.1.3"},
+	"sysName":                      {oid: "1.3.6.1.2.1.1.5"},
+	"ifAdminStatus":                {oid: "1.3.6.1.2.1.2.2.1.7", enum: ifStatus},
+	"ifOperStatus":                 {oid: "1.3.6.1.2.1.2.2.1.8", enum: ifStatus},
+	"ifInOctets":                   {oid: "1.3.6.1.2.1.2.2.1.10"},
+	"ifInErrors":                   {oid: "1.3.6.1.2.1.2.2.1.14"},
+	"ifOutOctets":                  {oid: "1.3.6.1.2.1.2.2.1.16"},
+	"ifOutErrors":                  {oid: "1.3.6.1.2.1.2.2.1.20"},
+	"ifHCInOctets":                 {oid: "1.3.6.1.2.1.31.1.1.1.6"},
+	"ifHCOutOctets":                {oid: "1.3.6.1.2.1.31.1.1.1.10"},
+	"hrProcessorLoad":              {oid: "1.3.6.1.2.1.25.3.3.1.2"},
+	"prtMarkerSuppliesLevel":       {oid: "1.3.6.1.2.1.43.11.1.1.9"},
+	"upsBatteryStatus":             {oid: "1.3.6.1.2.1.33.1.2.1", enum: map[int]string{1: "unknown", 2: "batteryNormal", 3: "batteryLow", 4: "batteryDepleted"}},
+	"upsEstimatedMinutesRemaining": {oid: "1.3.6.1.2.1.33.1.2.3"},
+	"upsEstimatedChargeRemaining":  {oid: "1.3.6.1.2.1.33.1.2.4"},
+	"upsOutputSource":              {oid: "1.3.6.1.2.1.33.1.4.1", enum: map[int]string{1: "other", 2: "none", 3: "normal", 4: "bypass", 5: "battery", 6: "booster", 7: "reducer"}},
+}
+
+// snmpResolve translates the name, numeric or starting with one of
+// snmpNames, to the numeric oid.
+func snmpResolve(name string) snmpObject {
+	name = strings.TrimPrefix(name, ".")
+	first, rest := name, ""
+	if i := strings.Index(name, "."); i >= 0 {
+		first, rest = name[:i], name[i:]
+	}
+	if obj, ok := snmpNames[first]; ok {
+		return snmpObject{oid: "." + obj.oid + rest, enum: obj.enum}
+	}
+	return snmpObject{oid: "." + name}
+}
+
+var snmpAuth = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var snmpPriv = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+// snmpClient configures the client for the url and the keys of
+// CheckSnmp.
+func snmpClient(m *Monitor, url *url.URL, opts Options) (*gosnmp.GoSNMP, error) {
+	port := 161
+	if p := url.Port(); p != "" {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, e.New("invalid port %v", p)
+		}
+		port = int(n)
+	}
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	target := url.Hostname()
+	if d.Address != "" {
+		target = d.Address
+	}
+	client := &gosnmp.GoSNMP{
+		Target:             target,
+		Port:               uint16(port),
+		Transport:          "udp",
+		Timeout:            budget(m),
+		Retries:            0,
+		MaxOids:            gosnmp.MaxOids,
+		ExponentialTimeout: false,
+		Control:            bindControl(d.Interface),
+	}
+	if d.Source != nil {
+		client.LocalAddr = net.JoinHostPort(d.Source.String(), "0")
+	}
+	name := ""
+	if url.User != nil {
+		name = url.User.Username()
+	}
+	switch v := opts.String("version", "2c"); v {
+	case "1", "2c":
+		client.Version = gosnmp.Version2c
+		if v == "1" {
+			client.Version = gosnmp.Version1
+		}
+		client.Community = opts.String("community", name)
+		if client.Community == "" {
+			client.Community = "public"
+		}
+	case "3":
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.ContextName = opts.String("context", "")
+		usm := &gosnmp.UsmSecurityParameters{
+			UserName:               opts.String("user", name),
+			AuthenticationProtocol: gosnmp.NoAuth,
+			PrivacyProtocol:        gosnmp.NoPriv,
+		}
+		if usm.UserName == "" {
+			return nil, e.New("snmp v3 needs an user")
+		}
+		client.MsgFlags = gosnmp.NoAuthNoPriv
+		if pass := opts.String("authpass", ""); pass != "" {
+			proto, ok := snmpAuth[strings.ToUpper(opts.String("authproto", "SHA"))]
+			if !ok {
+				return nil, e.New("invalid authproto %v", opts.String("authproto", ""))
+			}
+			usm.AuthenticationProtocol = proto
+			usm.AuthenticationPassphrase = pass
+			client.MsgFlags = gosnmp.AuthNoPriv
+			if pass := opts.String("privpass", ""); pass != "" {
+				proto, ok := snmpPriv[strings.ToUpper(opts.String("privproto", "AES"))]
+				if !ok {
+					return nil, e.New("invalid privproto %v", opts.String("privproto", ""))
+				}
+				usm.PrivacyProtocol = proto
+				usm.PrivacyPassphrase = pass
+				client.MsgFlags = gosnmp.AuthPriv
+			}
+		} else if opts.String("privpass", "") != "" {
+			return nil, e.New("privpass needs authpass")
+		}
+		client.SecurityParameters = usm
+	default:
+		return nil, e.New("invalid snmp version %v", v)
+	}
+	return client, nil
+}
+
+// snmpValue formats the value of the variable. Counters are returned as
+// numbers with counter true.
+func snmpValue(v gosnmp.SnmpPDU) (value string, counter bool, err error) {
+	switch v.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+		return "", false, e.New("%v: %v", v.Name, v.Type)
+	case gosnmp.OctetString, gosnmp.BitString:
+		b, _ := v.Value.([]byte)
+		if utf8.Valid(b) {
+			return strings.TrimRight(string(b), "\x00"), false, nil
+		}
+		return hex.EncodeToString(b), false, nil
+	case gosnmp.Counter32, gosnmp.Counter64:
+		return gosnmp.ToBigInt(v.Value).String(), true, nil
+	case gosnmp.Integer, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(v.Value).String(), false, nil
+	}
+	return fmt.Sprint(v.Value), false, nil
+}
+
+// CheckSnmp gets the object in snmp://[community@]host[:port]/oid and
+// the objects in the key oids. The oids can be numeric or start with the
+// names in snmpNames, like ifOperStatus.2. The key expect is the
+// condition of the url object and expect.<oid> of the others, see
+// compare. Enumerations can be compared by name (expect=up) and the
+// counters are compared as rates per second, from the second poll on.
+// The keys are:
+//
+//	version   1, 2c (default) or 3.
+//	community community of v1 and v2c, the url user or public.
+//	user      v3 user, the url user.
+//	authproto MD5, SHA (default), SHA224, SHA256, SHA384 or SHA512.
+//	authpass  v3 authentication passphrase.
+//	privproto DES, AES (default), AES192, AES256, AES192C or AES256C.
+//	privpass  v3 privacy passphrase.
+//	context   v3 context name.
+func CheckSnmp(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	names := make([]string, 0)
+	if p := strings.Trim(url.Path, "/"); p != "" {
+		names = append(names, p)
+	}
+	names = append(names, opts.List("oids")...)
+	if len(names) == 0 {
+		return nil, e.New("no oid to get")
+	}
+	conds := make(map[string]string)
+	for _, name := range names {
+		if c := opts.String("expect."+name, ""); c != "" {
+			conds[name] = c
+		}
+	}
+	if c := opts.String("expect", ""); c != "" {
+		conds[names[0]] = c
+	}
+
+	client, err := snmpClient(m, url, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	err = client.Connect()
+	if err != nil {
+		return nil, e.New(err)
+	}
+	defer client.Conn.Close()
+
+	objs := make([]snmpObject, len(names))
+	oids := make([]string, len(names))
+	for i, name := range names {
+		objs[i] = snmpResolve(name)
+		oids[i] = objs[i].oid
+	}
+	pkt, err := client.Get(oids)
+	if err != nil {
+		return nil, e.Push(e.New(err), "get failed")
+	}
+	if pkt.Error != gosnmp.NoError {
+		return nil, e.New("get failed: %v", pkt.Error)
+	}
+	got := make(map[string]gosnmp.SnmpPDU)
+	for _, v := range pkt.Variables {
+		got[v.Name] = v
+	}
+
+	res := &Result{}
+	values := make([]string, 0, len(names))
+	failed := make([]string, 0)
+	for i, name := range names {
+		v, ok := got[objs[i].oid]
+		if !ok {
+			return res, e.New("%v missing in the reply", name)
+		}
+		value, counter, err := snmpValue(v)
+		if err != nil {
+			res.State = StateCritical
+			return res, e.Push(err, "can't get "+name)
+		}
+		shown := value
+		if counter {
+			n, _ := strconv.ParseFloat(value, 64)
+			r, ok := rate(m, name, n)
+			if !ok {
+				values = append(values, name+" = "+value+" (rate on the next poll)")
+				continue
+			}
+			value = strconv.FormatFloat(round(r), 'f', -1, 64)
+			shown = value + "/s"
+		}
+		cond := conds[name]
+		subject := value
+		if objs[i].enum != nil {
+			n, _ := strconv.Atoi(value)
+			if s, ok := objs[i].enum[n]; ok {
+				shown = fmt.Sprintf("%v(%v)", s, n)
+			}
+			subject, cond = snmpEnumCond(value, cond, objs[i].enum)
+		}
+		values = append(values, name+" = "+shown)
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			res.Perf = append(res.Perf, Perf{Label: name, Value: n})
+		}
+		if cond == "" {
+			continue
+		}
+		ok, err = compare(subject, cond)
+		if err != nil {
+			return res, e.Push(err, "can't check "+name)
+		}
+		if !ok {
+			failed = append(failed, fmt.Sprintf("%v = %v, expected %v", name, shown, conds[name]))
+		}
+	}
+	res.Summary = strings.Join(values, ", ")
+	if len(failed) > 0 {
+		res.State = StateCritical
+		res.Detail = res.Summary
+		res.Summary = strings.Join(failed, ", ")
+		return res, e.New("%v", res.Summary)
+	}
+	return res, nil
+}
+
+// snmpEnumCond returns the value and the condition to compare for the
+// enumeration: the regexps of ~ match the name of the value, the other
+// operators compare the number, so the name in the condition is
+// replaced by its number.
+func snmpEnumCond(value, cond string, enum map[int]string) (string, string) {
+	if strings.HasPrefix(strings.TrimSpace(cond), "~") {
+		n, err := strconv.Atoi(value)
+		if s, ok := enum[n]; err == nil && ok {
+			return s, cond
+		}
+		return value, cond
+	}
+	trimmed := strings.TrimLeft(cond, "=!<> ")
+	op := cond[:len(cond)-len(trimmed)]
+	for n, s := range enum {
+		if strings.EqualFold(s, strings.TrimSpace(trimmed)) {
+			return value, op + strconv.Itoa(n)
+		}
+	}
+	return value, cond
+}
+
+func init() {
+	Add("snmp", CheckSnmp)
+}
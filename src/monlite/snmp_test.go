@@ -0,0 +1,38 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import "testing"
+
+func TestSnmpEnumCond(t *testing.T) {
+	tests := []struct {
+		value, cond string
+		ok          bool
+	}{
+		{"1", "up", true},
+		{"1", "== up", true},
+		{"2", "up", false},
+		{"2", "!=up", true},
+		{"2", " != UP", true},
+		{"3", "~^(up|testing)$", true},
+		{"2", "~^(up|testing)$", false},
+		{"1", "~ ^u", true},
+		// The number without a name is matched as is.
+		{"9", "~^9$", true},
+		{"1", ">= 1", true},
+		{"1", "other", false},
+	}
+	for _, tt := range tests {
+		subject, cond := snmpEnumCond(tt.value, tt.cond, ifStatus)
+		ok, err := compare(subject, cond)
+		if err != nil {
+			t.Errorf("%v %q: %v", tt.value, tt.cond, err)
+			continue
+		}
+		if ok != tt.ok {
+			t.Errorf("%v %q compared %q %q = %v, expected %v", tt.value, tt.cond, subject, cond, ok, tt.ok)
+		}
+	}
+}
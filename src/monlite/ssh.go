@@ -0,0 +1,256 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// bannerConn keeps the first line read from the connection, the server
+// identification of ssh.
+type bannerConn struct {
+	net.Conn
+	mutex  sync.Mutex
+	banner []byte
+	done   bool
+}
+
+func (c *bannerConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.done && n > 0 {
+		c.banner = append(c.banner, p[:n]...)
+		if i := bytes.IndexByte(c.banner, '\n'); i >= 0 {
+			c.banner = c.banner[:i]
+			c.done = true
+		}
+	}
+	return n, err
+}
+
+func (c *bannerConn) Banner() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return strings.TrimSpace(string(c.banner))
+}
+
+// CheckSSH reads the ssh server identification, completes the key
+// exchange and verifies the host key of ssh://[user[:password]@]host[:port].
+// The keys are:
+//
+//	banner      regexp the server identification must match.
+//	fingerprint comma separated list of the accepted host key
+//	            fingerprints (SHA256:...).
+//	knownhosts  known_hosts file used to verify the host key.
+//	keyfile     private key to authenticate, with the optional key
+//	            passphrase. Without it, or a password in the url, the
+//	            check stops after the key exchange. To authenticate
+//	            fingerprint or knownhosts is required.
+//	command     command run after the authentication.
+//	exit        exit status of the command (0).
+//	expect      regexp the command output must match.
+func CheckSSH(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	host := url.Host
+	if url.Port() == "" {
+		host = net.JoinHostPort(url.Hostname(), "22")
+	}
+
+	var fingerprint string
+	config, err := sshConfig(m, url, opts, &fingerprint)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	auth := config.Auth
+
+	start := time.Now()
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	raw, err := d.Dial("tcp", host)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(budget(m)))
+	conn := &bannerConn{Conn: raw}
+
+	kexDone := false
+	hostKey := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := hostKey(hostname, remote, key)
+		kexDone = err == nil
+		return err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, config)
+
+	res := &Result{
+		Summary: conn.Banner(),
+		Perf:    []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}},
+	}
+	if fingerprint != "" {
+		res.Detail = "host key " + fingerprint
+	}
+	if re := opts.String("banner", ""); re != "" {
+		r, err := regexp.Compile(re)
+		if err != nil {
+			return res, e.Push(e.New(err), "invalid banner")
+		}
+		if !r.MatchString(conn.Banner()) {
+			res.State = StateCritical
+			return res, e.New("banner %q doesn't match %v", conn.Banner(), re)
+		}
+	}
+	if !kexDone {
+		res.State = StateCritical
+		if err == nil {
+			err = e.New("key exchange failed")
+		}
+		return res, e.Push(e.New(err), "key exchange failed")
+	}
+	if len(auth) == 0 {
+		// The server refuses the none authentication, but the key
+		// exchange was done and the host key verified.
+		if c != nil {
+			c.Close()
+		}
+		return res, nil
+	}
+	if err != nil {
+		res.State = StateCritical
+		return res, e.Push(e.New(err), "authentication failed")
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	defer client.Close()
+
+	command := opts.String("command", "")
+	if command == "" {
+		return res, nil
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return res, e.Push(e.New(err), "can't open a session")
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput(command)
+	status := 0
+	if exit, ok := err.(*ssh.ExitError); ok {
+		status = exit.ExitStatus()
+	} else if err != nil {
+		return res, e.Push(e.New(err), "can't run "+command)
+	}
+	res.Detail += "\n\noutput:\n" + snippet(out)
+	want, err := opts.Int("exit", 0)
+	if err != nil {
+		return res, e.Forward(err)
+	}
+	if status != want {
+		res.State = StateCritical
+		return res, e.New("%v exited with %v, expected %v", command, status, want)
+	}
+	if expect := opts.String("expect", ""); expect != "" {
+		r, err := regexp.Compile(expect)
+		if err != nil {
+			return res, e.Push(e.New(err), "invalid expect")
+		}
+		if !r.Match(out) {
+			res.State = StateCritical
+			return res, e.New("%v output doesn't match %v", command, expect)
+		}
+	}
+	res.Summary += fmt.Sprintf(", %v exited with %v", command, status)
+	return res, nil
+}
+
+// sshConfig creates the client configuration from the user and password
+// of the url and the keys user, fingerprint, knownhosts, keyfile and
+// passphrase. The SHA256 fingerprint of the host key is stored in
+// fingerprint during the key exchange. The credentials aren't sent to a
+// host key that isn't verified: with a password or a key, fingerprint or
+// knownhosts is required.
+func sshConfig(m *Monitor, url *url.URL, opts Options, fingerprint *string) (*ssh.ClientConfig, error) {
+	var verify ssh.HostKeyCallback
+	if file := opts.String("knownhosts", ""); file != "" {
+		var err error
+		verify, err = knownhosts.New(file)
+		if err != nil {
+			return nil, e.Push(e.New(err), "can't read "+file)
+		}
+	}
+	pinned := opts.List("fingerprint")
+	hostKey := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		*fingerprint = ssh.FingerprintSHA256(key)
+		if len(pinned) > 0 {
+			found := false
+			for _, f := range pinned {
+				if f == *fingerprint {
+					found = true
+				}
+			}
+			if !found {
+				return e.New("host key %v %v isn't pinned", key.Type(), *fingerprint)
+			}
+		}
+		if verify != nil {
+			return verify(hostname, remote, key)
+		}
+		return nil
+	}
+
+	user := opts.String("user", "")
+	auth := make([]ssh.AuthMethod, 0)
+	if url.User != nil {
+		user = url.User.Username()
+		if pass, ok := url.User.Password(); ok {
+			auth = append(auth, ssh.Password(pass))
+		}
+	}
+	if file := opts.String("keyfile", ""); file != "" {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, e.New(err)
+		}
+		var signer ssh.Signer
+		if pass := opts.String("passphrase", ""); pass != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(pass))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, e.Push(e.New(err), "invalid key "+file)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(auth) > 0 && verify == nil && len(pinned) == 0 {
+		return nil, e.New("refusing to authenticate without the fingerprint or knownhosts keys to verify the host key")
+	}
+	if user == "" {
+		user = "monlite"
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         budget(m),
+	}, nil
+}
+
+func init() {
+	Add("ssh", CheckSSH)
+}
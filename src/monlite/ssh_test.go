@@ -0,0 +1,147 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeSSH is an ssh server that counts the passwords it receives.
+type fakeSSH struct {
+	addr        string
+	key         ssh.PublicKey
+	fingerprint string
+	passwords   int32
+}
+
+func newFakeSSH(t *testing.T) *fakeSSH {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeSSH{
+		addr:        ln.Addr().String(),
+		key:         signer.PublicKey(),
+		fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+	}
+	config := &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-OpenSSH_9.6",
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			atomic.AddInt32(&f.passwords, 1)
+			if string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, e.New("wrong password")
+		},
+	}
+	config.AddHostKey(signer)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					nc.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	return f
+}
+
+func TestCheckSSH(t *testing.T) {
+	f := newFakeSSH(t)
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(f.addr)}, f.key)
+	if err := os.WriteFile(known, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wrongKnown := filepath.Join(t.TempDir(), "wrong_known_hosts")
+	line = knownhosts.Line([]string{knownhosts.Normalize(f.addr)}, otherSigner.PublicKey())
+	if err := os.WriteFile(wrongKnown, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		user      string
+		opts      Options
+		err       string
+		passwords int32
+	}{
+		{"key exchange", "", Options{"banner": "^SSH-2.0-OpenSSH_9"}, "", 0},
+		{"banner", "", Options{"banner": "dropbear"}, "doesn't match dropbear", 0},
+		{"pinned", "", Options{"fingerprint": f.fingerprint}, "", 0},
+		{"pinned login", "user:secret@", Options{"fingerprint": "SHA256:other," + f.fingerprint}, "", 1},
+		{"known hosts login", "user:secret@", Options{"knownhosts": known}, "", 1},
+		{"wrong password", "user:wrong@", Options{"fingerprint": f.fingerprint}, "authentication failed", 1},
+		{"not pinned", "", Options{"fingerprint": ssh.FingerprintSHA256(otherSigner.PublicKey())}, "isn't pinned", 0},
+		{"not pinned login", "user:secret@", Options{"fingerprint": ssh.FingerprintSHA256(otherSigner.PublicKey())}, "isn't pinned", 0},
+		{"other known host", "user:secret@", Options{"knownhosts": wrongKnown}, "key mismatch", 0},
+		{"unverified login", "user:secret@", nil, "refusing to authenticate without the fingerprint or knownhosts", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&f.passwords, 0)
+			m := &Monitor{
+				Name:    "ssh " + tt.name,
+				Url:     "ssh://" + tt.user + f.addr,
+				Timeout: 5 * time.Second,
+				Options: tt.opts,
+			}
+			res, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+				if !strings.Contains(res.Detail, f.fingerprint) {
+					t.Fatalf("detail %q without the host key", res.Detail)
+				}
+			} else if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q", err, tt.err)
+			}
+			if n := atomic.LoadInt32(&f.passwords); n != tt.passwords {
+				t.Fatalf("the server got %v passwords, expected %v", n, tt.passwords)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/fcavani/e"
+)
+
+// ProcDir is where the proc filesystem is mounted.
+var ProcDir = "/proc"
+
+// CheckSystem checks the resources of the host where monlite runs. The
+// url host is the resource:
+//
+//	system://disk/var   space used in the mount, in percent, must be
+//	                    less than max (90). Without path all mounts
+//	                    are checked.
+//	system://inodes/var the same for the inodes.
+//	system://load       load average (key avg 1, 5 or 15, default 5)
+//	                    divided by the number of cpus must be less
+//	                    than max (2).
+//	system://memory     available memory, in percent, must be greater
+//	                    than min (10).
+//	system://swap       swap used, in percent, must be less than max (50).
+//	system://raid       no md array in /proc/mdstat may be degraded.
+//	                    Without /proc/mdstat there are no arrays.
+//	system://net/eth0   the interface errors since the last check must
+//	                    be less or equal than max (0). Without path all
+//	                    interfaces, but lo, are checked.
+func CheckSystem(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	var res *Result
+	var err error
+	switch url.Host {
+	case "disk":
+		res, err = checkDisk(opts, url.Path, false)
+	case "inodes":
+		res, err = checkDisk(opts, url.Path, true)
+	case "load":
+		res, err = checkLoad(opts)
+	case "memory":
+		res, err = checkMemory(opts)
+	case "swap":
+		res, err = checkSwap(opts)
+	case "raid":
+		res, err = checkRaid()
+	case "net":
+		res, err = checkNet(m, opts, strings.Trim(url.Path, "/"))
+	default:
+		return nil, e.New("unknown system resource %v", url.Host)
+	}
+	if err != nil {
+		return res, e.Forward(err)
+	}
+	return res, nil
+}
+
+// pseudoFs are the file systems without disk space.
+var pseudoFs = map[string]bool{
+	"proc": true, "sysfs": true, "devtmpfs": true, "devpts": true,
+	"tmpfs": true, "cgroup": true, "cgroup2": true, "securityfs": true,
+	"pstore": true, "debugfs": true, "tracefs": true, "configfs": true,
+	"mqueue": true, "hugetlbfs": true, "autofs": true, "binfmt_misc": true,
+	"fusectl": true, "bpf": true, "rpc_pipefs": true, "nsfs": true,
+	"squashfs": true, "efivarfs": true, "selinuxfs": true,
+}
+
+func mounts() ([]string, error) {
+	f, err := os.Open(ProcDir + "/mounts")
+	if err != nil {
+		return nil, e.New(err)
+	}
+	defer f.Close()
+	list := make([]string, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || pseudoFs[fields[2]] {
+			continue
+		}
+		path := unescapeMount(fields[1])
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		list = append(list, path)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, e.New(err)
+	}
+	return list, nil
+}
+
+var octal = regexp.MustCompile(`\\[0-7]{3}`)
+
+// unescapeMount undo the octal escapes of /proc/mounts.
+func unescapeMount(s string) string {
+	return octal.ReplaceAllStringFunc(s, func(o string) string {
+		n, _ := strconv.ParseUint(o[1:], 8, 8)
+		return string([]byte{byte(n)})
+	})
+}
+
+func checkDisk(opts Options, path string, inodes bool) (*Result, error) {
+	max, err := opts.Float("max", 90)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	paths := []string{path}
+	if path == "" {
+		paths, err = mounts()
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+	}
+	what := "space"
+	if inodes {
+		what = "inodes"
+	}
+	res := &Result{}
+	failed := make([]string, 0)
+	for _, p := range paths {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(p, &st)
+		if err != nil {
+			if path == "" {
+				continue
+			}
+			return nil, e.Push(e.New(err), "can't stat "+p)
+		}
+		var used, total float64
+		if inodes {
+			used = float64(st.Files - st.Ffree)
+			total = float64(st.Files)
+		} else {
+			used = float64(st.Blocks - st.Bfree)
+			total = used + float64(st.Bavail)
+		}
+		if total == 0 {
+			continue
+		}
+		pct := used / total * 100
+		res.Perf = append(res.Perf, Perf{
+			Label: p,
+			Value: round(pct),
+			Unit:  "%",
+			Crit:  strconv.FormatFloat(max, 'f', -1, 64),
+			Min:   "0",
+			Max:   "100",
+		})
+		if pct >= max {
+			failed = append(failed, fmt.Sprintf("%v %.1f%%", p, pct))
+		}
+	}
+	if len(failed) > 0 {
+		res.State = StateCritical
+		res.Summary = what + " used above " + strconv.FormatFloat(max, 'f', -1, 64) + "%: " + strings.Join(failed, ", ")
+		return res, e.New("%v", res.Summary)
+	}
+	res.Summary = fmt.Sprintf("%v used below %v%% in %v mounts", what, max, len(res.Perf))
+	return res, nil
+}
+
+func checkLoad(opts Options) (*Result, error) {
+	max, err := opts.Float("max", 2)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	avg := map[string]int{"1": 0, "5": 1, "15": 2}
+	i, ok := avg[opts.String("avg", "5")]
+	if !ok {
+		return nil, e.New("avg must be 1, 5 or 15")
+	}
+	data, err := ioutil.ReadFile(ProcDir + "/loadavg")
+	if err != nil {
+		return nil, e.New(err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return nil, e.New("invalid loadavg")
+	}
+	load, err := strconv.ParseFloat(fields[i], 64)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	cpus := runtime.NumCPU()
+	perCpu := load / float64(cpus)
+	res := &Result{
+		Summary: fmt.Sprintf("load %v on %v cpus, %.2f per cpu", load, cpus, perCpu),
+		Perf: []Perf{{
+			Label: "load" + opts.String("avg", "5"),
+			Value: round(perCpu),
+			Crit:  strconv.FormatFloat(max, 'f', -1, 64),
+			Min:   "0",
+		}},
+	}
+	if perCpu >= max {
+		res.State = StateCritical
+		return res, e.New("%v", res.Summary)
+	}
+	return res, nil
+}
+
+// meminfo reads /proc/meminfo in kB.
+func meminfo() (map[string]float64, error) {
+	f, err := os.Open(ProcDir + "/meminfo")
+	if err != nil {
+		return nil, e.New(err)
+	}
+	defer f.Close()
+	info := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		info[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, e.New(err)
+	}
+	return info, nil
+}
+
+func checkMemory(opts Options) (*Result, error) {
+	min, err := opts.Float("min", 10)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	info, err := meminfo()
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	total := info["MemTotal"]
+	avail, ok := info["MemAvailable"]
+	if !ok {
+		// Before Linux 3.14.
+		avail = info["MemFree"] + info["Buffers"] + info["Cached"]
+	}
+	if total == 0 {
+		return nil, e.New("invalid meminfo")
+	}
+	pct := avail / total * 100
+	res := &Result{
+		Summary: fmt.Sprintf("%.1f%% of memory available (%v MB of %v MB)", pct, int(avail/1024), int(total/1024)),
+		Perf: []Perf{{
+			Label: "available",
+			Value: round(pct),
+			Unit:  "%",
+			Crit:  strconv.FormatFloat(min, 'f', -1, 64) + ":",
+			Min:   "0",
+			Max:   "100",
+		}},
+	}
+	if pct <= min {
+		res.State = StateCritical
+		return res, e.New("%v", res.Summary)
+	}
+	return res, nil
+}
+
+func checkSwap(opts Options) (*Result, error) {
+	max, err := opts.Float("max", 50)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	info, err := meminfo()
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	total := info["SwapTotal"]
+	if total == 0 {
+		return &Result{Summary: "no swap"}, nil
+	}
+	pct := (total - info["SwapFree"]) / total * 100
+	res := &Result{
+		Summary: fmt.Sprintf("%.1f%% of swap used", pct),
+		Perf: []Perf{{
+			Label: "swap",
+			Value: round(pct),
+			Unit:  "%",
+			Crit:  strconv.FormatFloat(max, 'f', -1, 64),
+			Min:   "0",
+			Max:   "100",
+		}},
+	}
+	if pct >= max {
+		res.State = StateCritical
+		return res, e.New("%v", res.Summary)
+	}
+	return res, nil
+}
+
+var mdStatus = regexp.MustCompile(`\[(\d+)/(\d+)\]\s+\[([U_]+)\]`)
+
+func checkRaid() (*Result, error) {
+	f, err := os.Open(ProcDir + "/mdstat")
+	if os.IsNotExist(err) {
+		// The md driver isn't loaded.
+		return &Result{Summary: "no arrays"}, nil
+	} else if err != nil {
+		return nil, e.New(err)
+	}
+	defer f.Close()
+	res := &Result{}
+	arrays := 0
+	degraded := make([]string, 0)
+	dev := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "md") {
+			dev = strings.Fields(line)[0]
+			arrays++
+			continue
+		}
+		sub := mdStatus.FindStringSubmatch(line)
+		if sub == nil || dev == "" {
+			continue
+		}
+		if sub[1] != sub[2] || strings.Contains(sub[3], "_") {
+			degraded = append(degraded, fmt.Sprintf("%v [%v/%v] [%v]", dev, sub[1], sub[2], sub[3]))
+		}
+		dev = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, e.New(err)
+	}
+	if len(degraded) > 0 {
+		res.State = StateCritical
+		res.Summary = "degraded arrays: " + strings.Join(degraded, ", ")
+		return res, e.New("%v", res.Summary)
+	}
+	res.Summary = fmt.Sprintf("%v arrays ok", arrays)
+	return res, nil
+}
+
+func checkNet(m *Monitor, opts Options, iface string) (*Result, error) {
+	max, err := opts.Float("max", 0)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	f, err := os.Open(ProcDir + "/net/dev")
+	if err != nil {
+		return nil, e.New(err)
+	}
+	defer f.Close()
+	res := &Result{}
+	failed := make([]string, 0)
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.SplitN(scanner.Text(), ":", 2)
+		if len(line) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(line[0])
+		if iface == "" && name == "lo" || iface != "" && name != iface {
+			continue
+		}
+		found = true
+		// rx: bytes packets errs drop ... tx: bytes packets errs drop ...
+		fields := strings.Fields(line[1])
+		if len(fields) < 12 {
+			continue
+		}
+		rx, _ := strconv.ParseFloat(fields[2], 64)
+		tx, _ := strconv.ParseFloat(fields[10], 64)
+		res.Perf = append(res.Perf,
+			Perf{Label: name + "_rx_errors", Value: rx, Unit: "c"},
+			Perf{Label: name + "_tx_errors", Value: tx, Unit: "c"},
+		)
+		d, _, ok := delta(m, name, rx+tx)
+		if ok && d > max {
+			failed = append(failed, fmt.Sprintf("%v %v new errors", name, d))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, e.New(err)
+	}
+	if iface != "" && !found {
+		return nil, e.New("interface %v not found", iface)
+	}
+	if len(failed) > 0 {
+		res.State = StateCritical
+		res.Summary = strings.Join(failed, ", ")
+		return res, e.New("%v", res.Summary)
+	}
+	res.Summary = "no new interface errors"
+	return res, nil
+}
+
+func round(f float64) float64 {
+	return float64(int64(f*100+0.5)) / 100
+}
+
+func init() {
+	Add("system", CheckSystem)
+}
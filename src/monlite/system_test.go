@@ -0,0 +1,159 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fcavani/e"
+)
+
+// forgetSamples removes the counter samples and the values of the
+// monitor.
+func forgetSamples(m *Monitor) {
+	samplesMutex.Lock()
+	defer samplesMutex.Unlock()
+	for k := range samples {
+		if strings.HasPrefix(k, m.Name+"\x00") {
+			delete(samples, k)
+		}
+	}
+	for k := range values {
+		if strings.HasPrefix(k, m.Name+"\x00") {
+			delete(values, k)
+		}
+	}
+}
+
+// procFixture makes ProcDir a directory with the files, the key is the
+// path relative to /proc.
+func procFixture(t *testing.T, files map[string]string) {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := ProcDir
+	ProcDir = dir
+	t.Cleanup(func() { ProcDir = old })
+}
+
+func TestMounts(t *testing.T) {
+	procFixture(t, map[string]string{"mounts": `overlay / overlay rw,relatime 0 0
+proc /proc proc rw,nosuid 0 0
+tmpfs /dev tmpfs rw 0 0
+/dev/sda1 /mnt/my\040disk ext4 rw 0 0
+/dev/sda1 /mnt/my\040disk ext4 rw 0 0
+`})
+	got, err := mounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "/,/mnt/my disk" {
+		t.Fatalf("mounts %q, expected the overlay root and the escaped path once", got)
+	}
+}
+
+func TestCheckSystem(t *testing.T) {
+	cpus := float64(runtime.NumCPU())
+	meminfo := "MemTotal: 1000 kB\nMemFree: 50 kB\nMemAvailable: 200 kB\nSwapTotal: 100 kB\nSwapFree: 30 kB\n"
+	tests := []struct {
+		name  string
+		url   string
+		files map[string]string
+		opts  Options
+		state State
+		err   string
+	}{
+		{"load", "system://load", map[string]string{"loadavg": "0.00 " + strconv.FormatFloat(cpus, 'f', -1, 64) + " 0.00 1/100 1\n"}, nil, StateOk, ""},
+		{"load high", "system://load", map[string]string{"loadavg": "0.00 " + strconv.FormatFloat(3*cpus, 'f', -1, 64) + " 0.00 1/100 1\n"}, nil, StateCritical, "per cpu"},
+		{"load avg 15", "system://load", map[string]string{"loadavg": "0.00 0.00 " + strconv.FormatFloat(3*cpus, 'f', -1, 64) + " 1/100 1\n"}, Options{"avg": "15"}, StateCritical, "per cpu"},
+		{"load invalid avg", "system://load", map[string]string{"loadavg": "0 0 0\n"}, Options{"avg": "10"}, StateOk, "avg must be 1, 5 or 15"},
+		{"memory", "system://memory", map[string]string{"meminfo": meminfo}, nil, StateOk, ""},
+		{"memory low", "system://memory", map[string]string{"meminfo": meminfo}, Options{"min": "25"}, StateCritical, "20.0% of memory available"},
+		{"memory before 3.14", "system://memory", map[string]string{"meminfo": "MemTotal: 1000 kB\nMemFree: 50 kB\nBuffers: 10 kB\nCached: 40 kB\n"}, nil, StateCritical, "10.0% of memory available"},
+		{"swap", "system://swap", map[string]string{"meminfo": meminfo}, Options{"max": "80"}, StateOk, ""},
+		{"swap high", "system://swap", map[string]string{"meminfo": meminfo}, nil, StateCritical, "70.0% of swap used"},
+		{"no swap", "system://swap", map[string]string{"meminfo": "MemTotal: 1000 kB\nSwapTotal: 0 kB\n"}, nil, StateOk, ""},
+		{"raid", "system://raid", map[string]string{"mdstat": `Personalities : [raid1]
+md0 : active raid1 sdb1[1] sda1[0]
+      1046528 blocks super 1.2 [2/2] [UU]
+
+unused devices: <none>
+`}, nil, StateOk, ""},
+		{"raid degraded", "system://raid", map[string]string{"mdstat": `Personalities : [raid1]
+md0 : active raid1 sdb1[1] sda1[0]
+      1046528 blocks super 1.2 [2/2] [UU]
+
+md1 : active raid1 sdc1[0]
+      1046528 blocks super 1.2 [2/1] [U_]
+
+unused devices: <none>
+`}, nil, StateCritical, "degraded arrays: md1 [2/1] [U_]"},
+		{"no md driver", "system://raid", nil, nil, StateOk, ""},
+		{"disk", "system://disk", map[string]string{"mounts": "overlay / overlay rw 0 0\nproc /proc proc rw 0 0\n"}, Options{"max": "100"}, StateOk, ""},
+		{"disk full", "system://disk/", nil, Options{"max": "0"}, StateCritical, "space used above 0%"},
+		{"unknown resource", "system://cpu", nil, nil, StateOk, "unknown system resource cpu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procFixture(t, tt.files)
+			m := &Monitor{Name: "system " + tt.name, Url: tt.url, Options: tt.opts}
+			res, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+			} else if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+			if res != nil && res.State != tt.state {
+				t.Fatalf("state %v, expected %v: %v", res.State, tt.state, res.Summary)
+			}
+		})
+	}
+}
+
+func TestCheckSystemNet(t *testing.T) {
+	dev := func(eth0rx, eth0tx, loErrs int) string {
+		return `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 100 1 ` + strconv.Itoa(loErrs) + ` 0 0 0 0 0 100 1 0 0 0 0 0 0
+  eth0: 2000 20 ` + strconv.Itoa(eth0rx) + ` 0 0 0 0 0 3000 30 ` + strconv.Itoa(eth0tx) + ` 0 0 0 0 0
+`
+	}
+	m := &Monitor{Name: "system net", Url: "system://net"}
+	t.Cleanup(func() { forgetSamples(m) })
+	run := func(content string) error {
+		procFixture(t, map[string]string{"net/dev": content})
+		_, err := check(m)
+		return err
+	}
+	if err := run(dev(1, 1, 0)); err != nil {
+		t.Fatalf("first check: %v", err)
+	}
+	// The errors of lo don't count.
+	if err := run(dev(1, 1, 5)); err != nil {
+		t.Fatalf("no new errors: %v", err)
+	}
+	if err := run(dev(3, 2, 5)); err == nil || !strings.Contains(e.Trace(err), "eth0 3 new errors") {
+		t.Fatalf("new errors: %v", err)
+	}
+	m.Url = "system://net/eth1"
+	if err := run(dev(3, 2, 5)); err == nil || !strings.Contains(e.Trace(err), "interface eth1 not found") {
+		t.Fatalf("missing interface: %v", err)
+	}
+}
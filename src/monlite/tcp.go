@@ -0,0 +1,39 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+// CheckTCP connects to tcp://host:port through the dialer of the
+// monitor and closes the connection.
+func CheckTCP(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	start := time.Now()
+	conn, err := d.Dial("tcp", url.Host)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	err = conn.Close()
+	if err != nil {
+		return nil, e.New(err)
+	}
+	return &Result{
+		Summary: "connected to " + url.Host,
+		Perf:    []Perf{{Label: "connect", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}},
+	}, nil
+}
+
+func init() {
+	Add("tcp", CheckTCP)
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+
+	"github.com/fcavani/e"
+)
+
+// tlsConfig creates the tls configuration for host from the keys:
+//
+//	ca         file with the PEM certificates to trust, instead of the
+//	           system ones.
+//	cert, key  files with the PEM client certificate and key.
+//	insecure   if true the server certificate isn't verified.
+//	servername name to verify in the certificate (host). The http
+//	           checkers give an empty host, so the transport verifies
+//	           the host of each request, redirects included.
+func tlsConfig(opts Options, host string) (*tls.Config, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	insecure, err := opts.Bool("insecure", false)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	conf := &tls.Config{
+		ServerName:         opts.String("servername", host),
+		InsecureSkipVerify: insecure,
+	}
+	if ca := opts.String("ca", ""); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, e.New(err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, e.New("no certificate in %v", ca)
+		}
+	}
+	cert, key := opts.String("cert", ""), opts.String("key", "")
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, e.Push(e.New(err), "can't load the client certificate")
+		}
+		conf.Certificates = []tls.Certificate{pair}
+	}
+	return conf, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	utilUrl "github.com/fcavani/net/url"
+)
+
+// CheckUnix checks the unix socket in unix:///path/to.sock or, to also
+// have a path for the http mode, unix://unix(/path/to.sock)/path. The
+// scheme socket is the same. The socket paths are parsed like
+// ParseWithSocket of github.com/fcavani/net/url does. The key mode is:
+//
+//	connect only connects to the socket, the default.
+//	http    does a http request with the keys of httpRequest and
+//	        checks the response with the keys of httpAssert. The path
+//	        is the one in the url or the key path, the url query is
+//	        sent with it.
+//	send    writes the key send, where \n, \r and \t are escaped, and
+//	        reads the reply until it matches the regexp expect. It is
+//	        the default if send or expect are set.
+//
+// The keys are only the ones of the service section, not the url query.
+func CheckUnix(m *Monitor, url *url.URL) (*Result, error) {
+	parsed, err := utilUrl.ParseWithSocket(m.Url)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	opts := options(m, parsed)
+	path := parsed.Host
+	if _, p, err := utilUrl.Socket(parsed.Host); err == nil {
+		path = p
+	}
+	if path == "" {
+		return nil, e.New("no socket path in %v", m.Url)
+	}
+	mode := opts.String("mode", "connect")
+	if _, ok := opts["mode"]; !ok && (opts.String("send", "") != "" || opts.String("expect", "") != "") {
+		mode = "send"
+	}
+
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+
+	start := time.Now()
+	res := &Result{}
+	switch mode {
+	case "connect":
+		conn, err := d.Dial("unix", path)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		conn.Close()
+		res.Summary = "connected to " + path
+	case "http":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", path)
+			},
+			DisableKeepAlives: true,
+		}
+		client := &http.Client{Transport: transport, Timeout: budget(m)}
+		p := parsed.Path
+		if p == "" {
+			p = opts.String("path", "/")
+		}
+		if parsed.RawQuery != "" {
+			p += "?" + parsed.RawQuery
+		}
+		req, err := httpRequest(opts, "http://localhost"+p)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, e.Push(e.New(err), "request failed")
+		}
+		defer resp.Body.Close()
+		body, err := httpBody(resp)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		res.Summary = fmt.Sprintf("%v %v returned %v", req.Method, p, resp.Status)
+		err = httpAssert(opts, resp, body)
+		if err != nil {
+			res.State = StateCritical
+			res.Detail = "body:\n" + snippet(body)
+			return res, e.Forward(err)
+		}
+	case "send":
+		conn, err := d.Dial("unix", path)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(budget(m)))
+		reply, err := dialogue(conn, opts)
+		if len(reply) > 0 {
+			res.Detail = "received:\n" + snippet(reply)
+		}
+		if err != nil {
+			res.State = StateCritical
+			return res, e.Forward(err)
+		}
+		res.Summary = "dialogue with " + path + " done"
+	default:
+		return nil, e.New("invalid mode %v", mode)
+	}
+	res.Perf = []Perf{{Label: "time", Value: round(time.Since(start).Seconds() * 1000), Unit: "ms"}}
+	return res, nil
+}
+
+var unescapeDialogue = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\\`, `\`)
+
+// dialogue writes the key send in the connection and reads the reply
+// until it matches the regexp in the key expect. Without expect
+// nothing is read.
+func dialogue(conn net.Conn, opts Options) ([]byte, error) {
+	if send := opts.String("send", ""); send != "" {
+		_, err := conn.Write([]byte(unescapeDialogue.Replace(send)))
+		if err != nil {
+			return nil, e.Push(e.New(err), "can't send")
+		}
+	}
+	expect := opts.String("expect", "")
+	if expect == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expect)
+	if err != nil {
+		return nil, e.Push(e.New(err), "invalid expect")
+	}
+	var reply []byte
+	buf := make([]byte, 4096)
+	for int64(len(reply)) < MaxHTTPBody {
+		n, err := conn.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if re.Match(reply) {
+			return reply, nil
+		}
+		if err != nil {
+			return reply, e.Push(e.New(err), "reply doesn't match "+expect)
+		}
+	}
+	return reply, e.New("reply doesn't match %v", expect)
+}
+
+func init() {
+	Add("unix", CheckUnix)
+	Add("socket", CheckUnix)
+}
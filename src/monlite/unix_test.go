@@ -0,0 +1,59 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestCheckUnixHTTP(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "fpm.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery == "json" {
+			w.Write([]byte(`{"pool":"www"}`))
+			return
+		}
+		w.Write([]byte("pool: www"))
+	})}
+	go s.Serve(ln)
+	defer s.Close()
+	tests := []struct {
+		name string
+		url  string
+		opts Options
+		err  string
+	}{
+		{"query sent", "unix://unix(" + sock + ")/status?json", Options{"mode": "http", "expect": `"pool"`}, ""},
+		{"without query", "unix://unix(" + sock + ")/status", Options{"mode": "http", "expect": `"pool"`}, "doesn't match"},
+		// The query isn't read as options, mode is connect.
+		{"query isn't options", "socket://unix(" + sock + ")/status?mode=send&expect=x", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: tt.url, Timeout: 5 * time.Second, Options: tt.opts}
+			res, err := check(m)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(e.Trace(err))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(e.Trace(err), tt.err) {
+				t.Fatalf("error %v, expected %q, result %v", err, tt.err, res)
+			}
+		})
+	}
+}
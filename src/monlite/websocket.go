@@ -0,0 +1,304 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	utilUrl "github.com/fcavani/net/url"
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	wsText   = 0x1
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xa
+)
+
+// MaxWebSocketMessage is the biggest message read by CheckWebSocket.
+var MaxWebSocketMessage = 1 << 20
+
+// CheckWebSocket does the WebSocket handshake with the server in ws:// or
+// wss:// and, optionally, exchanges a message. The keys are:
+//
+//	header.<name> headers sent in the handshake, like header.Origin.
+//	protocols     comma separated list of subprotocols. The server
+//	              must choose one of them.
+//	send          the message sent after the handshake.
+//	binary        if true send is hex encoded and sent as binary.
+//	expect        regexp the first message received must match. If
+//	              send is set and expect isn't any reply is accepted.
+//
+// wss uses the TLS keys of tlsConfig. The handshake latency is in the
+// result.
+func CheckWebSocket(m *Monitor, url *url.URL) (*Result, error) {
+	opts := options(m, url)
+	d, err := dialer(m, opts)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	deadline := time.Now().Add(budget(m))
+
+	host := url.Host
+	if url.Port() == "" {
+		if url.Scheme == "wss" {
+			host = net.JoinHostPort(url.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(url.Hostname(), "80")
+		}
+	}
+	start := time.Now()
+	conn, err := d.Dial("tcp", host)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	if url.Scheme == "wss" {
+		conf, err := tlsConfig(opts, url.Host)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		tconn := tls.Client(conn, conf)
+		err = tconn.Handshake()
+		if err != nil {
+			return nil, e.Push(e.New(err), "tls handshake failed")
+		}
+		conn = tconn
+	}
+
+	key := make([]byte, 16)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	nonce := base64.StdEncoding.EncodeToString(key)
+	u := utilUrl.Copy(url)
+	u.Scheme = "http"
+	u.RawQuery = ""
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", nonce)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	protocols := opts.List("protocols")
+	if len(protocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+	for k, v := range opts.Prefixed("header") {
+		if strings.EqualFold(k, "host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	err = req.Write(conn)
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't send the handshake")
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, e.Push(e.New(err), "can't read the handshake")
+	}
+	resp.Body.Close()
+	latency := time.Since(start)
+	res := &Result{
+		Summary: fmt.Sprintf("handshake in %v", latency),
+		Perf:    []Perf{{Label: "handshake", Value: round(latency.Seconds() * 1000), Unit: "ms"}},
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return res, e.New("handshake returned status code %v, expected 101", resp.StatusCode)
+	}
+	sum := sha1.Sum([]byte(nonce + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return res, e.New("invalid Sec-WebSocket-Accept")
+	}
+	if len(protocols) > 0 {
+		chosen := resp.Header.Get("Sec-WebSocket-Protocol")
+		found := false
+		for _, p := range protocols {
+			if p == chosen {
+				found = true
+			}
+		}
+		if !found {
+			return res, e.New("server chose the subprotocol %q, expected one of %v", chosen, strings.Join(protocols, ", "))
+		}
+	}
+
+	send := opts.String("send", "")
+	expect := opts.String("expect", "")
+	if send == "" && expect == "" {
+		wsWriteFrame(conn, wsClose, []byte{0x03, 0xe8})
+		return res, nil
+	}
+	if send != "" {
+		op := byte(wsText)
+		payload := []byte(send)
+		bin, err := opts.Bool("binary", false)
+		if err != nil {
+			return res, e.Forward(err)
+		}
+		if bin {
+			op = wsBinary
+			payload, err = hex.DecodeString(send)
+			if err != nil {
+				return res, e.Push(e.New(err), "send must be hex encoded")
+			}
+		}
+		err = wsWriteFrame(conn, op, payload)
+		if err != nil {
+			return res, e.Push(e.New(err), "can't send the message")
+		}
+	}
+	msg, err := wsReadMessage(br, conn)
+	if err != nil {
+		return res, e.Push(err, "can't receive the reply")
+	}
+	wsWriteFrame(conn, wsClose, []byte{0x03, 0xe8})
+	rtt := time.Since(start) - latency
+	res.Perf = append(res.Perf, Perf{Label: "reply", Value: round(rtt.Seconds() * 1000), Unit: "ms"})
+	res.Summary += fmt.Sprintf(", reply in %v", rtt)
+	if expect != "" {
+		re, err := regexp.Compile(expect)
+		if err != nil {
+			return res, e.Push(e.New(err), "invalid expect")
+		}
+		if !re.Match(msg) {
+			res.State = StateCritical
+			res.Detail = "received:\n" + snippet(msg)
+			return res, e.New("reply doesn't match %v", expect)
+		}
+	}
+	return res, nil
+}
+
+// wsWriteFrame writes a masked, as the clients must do, final frame.
+func wsWriteFrame(w io.Writer, op byte, payload []byte) error {
+	frame := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	mask := make([]byte, 4)
+	_, err := rand.Read(mask)
+	if err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// wsReadMessage reads the next text or binary message, answering the
+// pings.
+func wsReadMessage(r io.Reader, w io.Writer) ([]byte, error) {
+	var msg []byte
+	for {
+		header := make([]byte, 2)
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return nil, e.New(err)
+		}
+		fin := header[0]&0x80 != 0
+		op := header[0] & 0x0f
+		n := uint64(header[1] & 0x7f)
+		switch n {
+		case 126:
+			ext := make([]byte, 2)
+			if _, err := io.ReadFull(r, ext); err != nil {
+				return nil, e.New(err)
+			}
+			n = uint64(binary.BigEndian.Uint16(ext))
+		case 127:
+			ext := make([]byte, 8)
+			if _, err := io.ReadFull(r, ext); err != nil {
+				return nil, e.New(err)
+			}
+			n = binary.BigEndian.Uint64(ext)
+		}
+		var mask []byte
+		if header[1]&0x80 != 0 {
+			mask = make([]byte, 4)
+			if _, err := io.ReadFull(r, mask); err != nil {
+				return nil, e.New(err)
+			}
+		}
+		if n > uint64(MaxWebSocketMessage) || len(msg)+int(n) > MaxWebSocketMessage {
+			return nil, e.New("message too big")
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, e.New(err)
+		}
+		if mask != nil {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+		switch op {
+		case wsPing:
+			wsWriteFrame(w, wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := 0
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			return nil, e.New("server closed the connection (%v)", code)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// snippet returns the beginning of data, for the alerts.
+func snippet(data []byte) string {
+	const max = 1024
+	if len(data) > max {
+		return string(data[:max]) + "..."
+	}
+	return string(data)
+}
+
+func init() {
+	Add("ws", CheckWebSocket)
+	Add("wss", CheckWebSocket)
+}
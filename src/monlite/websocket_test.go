@@ -0,0 +1,167 @@
+// Copyright 2015 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by Apache 2.0
+// license that can be found in the LICENSE file.
+
+package monlite
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsFrame encodes an unmasked frame, like the servers send.
+func wsFrame(fin bool, op byte, payload []byte) []byte {
+	b := op
+	if fin {
+		b |= 0x80
+	}
+	frame := []byte{b}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	return append(frame, payload...)
+}
+
+func TestWsWriteFrame(t *testing.T) {
+	for _, n := range []int{0, 1, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte{'x'}, n)
+		buf := bytes.NewBuffer(nil)
+		err := wsWriteFrame(buf, wsText, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Bytes()[0] != 0x80|wsText || buf.Bytes()[1]&0x80 == 0 {
+			t.Fatalf("%v bytes: frame isn't final and masked: %x", n, buf.Bytes()[:2])
+		}
+		msg, err := wsReadMessage(buf, ioutil.Discard)
+		if err != nil {
+			t.Fatalf("%v bytes: %v", n, err)
+		}
+		if !bytes.Equal(msg, payload) {
+			t.Fatalf("%v bytes: payload changed", n)
+		}
+	}
+}
+
+func TestWsReadMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		msg    string
+		pong   bool
+		err    string
+	}{
+		{"text", [][]byte{wsFrame(true, wsText, []byte("hello"))}, "hello", false, ""},
+		{"fragmented", [][]byte{
+			wsFrame(false, wsText, []byte("hel")),
+			wsFrame(true, 0, []byte("lo")),
+		}, "hello", false, ""},
+		{"ping between fragments", [][]byte{
+			wsFrame(false, wsText, []byte("hel")),
+			wsFrame(true, wsPing, []byte("p")),
+			wsFrame(true, 0, []byte("lo")),
+		}, "hello", true, ""},
+		{"pong ignored", [][]byte{
+			wsFrame(true, wsPong, nil),
+			wsFrame(true, wsBinary, []byte{1, 2}),
+		}, "\x01\x02", false, ""},
+		{"close", [][]byte{wsFrame(true, wsClose, []byte{0x03, 0xe9})}, "", false, "closed the connection (1001)"},
+		{"too big", [][]byte{wsFrame(true, wsText, bytes.Repeat([]byte{'x'}, 200))}, "", false, "too big"},
+		{"truncated", [][]byte{wsFrame(true, wsText, []byte("hello"))[:4]}, "", false, "EOF"},
+	}
+	defer func(max int) { MaxWebSocketMessage = max }(MaxWebSocketMessage)
+	MaxWebSocketMessage = 100
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			msg, err := wsReadMessage(bytes.NewReader(bytes.Join(tt.frames, nil)), out)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, expected %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(msg) != tt.msg {
+				t.Fatalf("message %q, expected %q", msg, tt.msg)
+			}
+			if tt.pong != (out.Len() > 0 && out.Bytes()[0] == 0x80|wsPong) {
+				t.Fatalf("pong %x", out.Bytes())
+			}
+		})
+	}
+}
+
+// wsEcho is an in-process WebSocket server that answers the messages
+// with "echo: " and the message, with the subprotocol chat.
+func wsEcho(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		brw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+		brw.WriteString("Sec-WebSocket-Protocol: chat\r\n\r\n")
+		brw.Flush()
+		msg, err := wsReadMessage(brw, conn)
+		if err != nil {
+			return
+		}
+		conn.Write(wsFrame(true, wsText, append([]byte("echo: "), msg...)))
+		wsReadMessage(brw, conn)
+	})
+}
+
+func TestCheckWebSocket(t *testing.T) {
+	s := httptest.NewServer(wsEcho(t))
+	defer s.Close()
+	url := "ws://" + s.Listener.Addr().String() + "/chat"
+	tests := []struct {
+		name string
+		opts Options
+		fail bool
+	}{
+		{"handshake", nil, false},
+		{"subprotocol", Options{"protocols": "other, chat"}, false},
+		{"wrong subprotocol", Options{"protocols": "other"}, true},
+		{"exchange", Options{"send": "hi", "expect": "^echo: hi$"}, false},
+		{"binary", Options{"send": "6869", "binary": "true", "expect": "^echo: hi$"}, false},
+		{"reply doesn't match", Options{"send": "hi", "expect": "^bye$"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Monitor{Name: tt.name, Url: url, Timeout: 5 * time.Second, Options: tt.opts}
+			res, err := check(m)
+			if tt.fail && err == nil {
+				t.Fatalf("expected an error, result %v", res)
+			}
+			if !tt.fail && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}